@@ -1,7 +1,20 @@
 package model
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // ChatLab format constants
@@ -29,62 +42,959 @@ const (
 	ChatLabTypeOther  = 99
 )
 
+var chatLabTypeNames = map[int]string{
+	ChatLabTypeText:      "TEXT",
+	ChatLabTypeImage:     "IMAGE",
+	ChatLabTypeVoice:     "VOICE",
+	ChatLabTypeVideo:     "VIDEO",
+	ChatLabTypeFile:      "FILE",
+	ChatLabTypeEmoji:     "EMOJI",
+	ChatLabTypeLink:      "LINK",
+	ChatLabTypeLocation:  "LOCATION",
+	ChatLabTypeRedPacket: "RED_PACKET",
+	ChatLabTypeTransfer:  "TRANSFER",
+	ChatLabTypePoke:      "POKE",
+	ChatLabTypeCall:      "CALL",
+	ChatLabTypeShare:     "SHARE",
+	ChatLabTypeReply:     "REPLY",
+	ChatLabTypeForward:   "FORWARD",
+	ChatLabTypeContact:   "CONTACT",
+	ChatLabTypeSystem:    "SYSTEM",
+	ChatLabTypeRecall:    "RECALL",
+	ChatLabTypeOther:     "OTHER",
+}
+
+// chatLabTypeLabels are the bracket labels used when a message is shown without its media
+var chatLabTypeLabels = map[int]string{
+	ChatLabTypeImage:     "[图片]",
+	ChatLabTypeVoice:     "[语音]",
+	ChatLabTypeVideo:     "[视频]",
+	ChatLabTypeFile:      "[文件]",
+	ChatLabTypeEmoji:     "[表情]",
+	ChatLabTypeLink:      "[链接]",
+	ChatLabTypeLocation:  "[位置]",
+	ChatLabTypeRedPacket: "[红包]",
+	ChatLabTypeTransfer:  "[转账]",
+	ChatLabTypeCall:      "[通话]",
+	ChatLabTypeShare:     "[分享]",
+	ChatLabTypeForward:   "[合并转发]",
+	ChatLabTypeContact:   "[名片]",
+}
+
+// ChatLabTypeName returns the spec name of a ChatLab message type, e.g. "IMAGE"
+func ChatLabTypeName(t int) string {
+	if name, ok := chatLabTypeNames[t]; ok {
+		return name
+	}
+	return chatLabTypeNames[ChatLabTypeOther]
+}
+
+// chatLabTypeLabel is the bracket label shown for type t without its content,
+// e.g. "[图片]", falling back to the spec name
+func chatLabTypeLabel(t int) string {
+	if label, ok := chatLabTypeLabels[t]; ok {
+		return label
+	}
+	return "[" + ChatLabTypeName(t) + "]"
+}
+
 type ChatLab struct {
 	ChatLab  ChatLabHeader    `json:"chatlab"`
 	Meta     ChatLabMeta      `json:"meta"`
+	Summary  *ChatLabSummary  `json:"summary,omitempty"`
 	Members  []ChatLabMember  `json:"members"`
 	Messages []ChatLabMessage `json:"messages"`
 }
 
 type ChatLabHeader struct {
-	Version     string `json:"version"`
-	ExportedAt  int64  `json:"exportedAt"`
-	Generator   string `json:"generator,omitempty"`
-	Description string `json:"description,omitempty"`
+	Version       string `json:"version"`
+	ExportedAt    int64  `json:"exportedAt"`
+	ExportedAtISO string `json:"exportedAtISO,omitempty"`
+	TimestampUnit string `json:"timestampUnit,omitempty"` // TimestampUnit*; empty means seconds
+	Generator     string `json:"generator,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Checksum      string `json:"checksum,omitempty"`
 }
 
 type ChatLabMeta struct {
-	Name        string `json:"name"`
-	Platform    string `json:"platform"`
-	Type        string `json:"type"`
-	GroupID     string `json:"groupId,omitempty"`
-	GroupAvatar string `json:"groupAvatar,omitempty"`
+	Name           string `json:"name"`
+	Platform       string `json:"platform"`
+	Type           string `json:"type"`
+	GroupID        string `json:"groupId,omitempty"`
+	GroupAvatar    string `json:"groupAvatar,omitempty"`
+	ConversationID string `json:"conversationId,omitempty"`
 }
 
 type ChatLabMember struct {
-	PlatformID    string   `json:"platformId"`
-	AccountName   string   `json:"accountName"`
-	GroupNickname string   `json:"groupNickname,omitempty"`
-	Aliases       []string `json:"aliases,omitempty"`
-	Avatar        string   `json:"avatar,omitempty"`
+	PlatformID        string   `json:"platformId"`
+	AccountName       string   `json:"accountName"`
+	GroupNickname     string   `json:"groupNickname,omitempty"`
+	Aliases           []string `json:"aliases,omitempty"`
+	Avatar            string   `json:"avatar,omitempty"`
+	IsSelf            bool     `json:"isSelf,omitempty"`
+	IsOfficialAccount bool     `json:"isOfficialAccount,omitempty"`
 }
 
 type ChatLabMessage struct {
-	Sender        string `json:"sender"`
-	AccountName   string `json:"accountName"`
-	GroupNickname string `json:"groupNickname,omitempty"`
-	Timestamp     int64  `json:"timestamp"`
-	Type          int    `json:"type"`
-	Content       string `json:"content"`
+	Sender          string `json:"sender"`
+	AccountName     string `json:"accountName"`
+	GroupNickname   string `json:"groupNickname,omitempty"`
+	Timestamp       int64  `json:"timestamp"`
+	TimestampISO    string `json:"timestampISO,omitempty"`
+	Type            int    `json:"type"`
+	Content         string `json:"content"`
+	Seq             int    `json:"seq,omitempty"`
+	PatFrom         string `json:"patFrom,omitempty"`
+	PatTo           string `json:"patTo,omitempty"`
+	PatSuffix       string `json:"patSuffix,omitempty"`
+	Thumb           string `json:"thumb,omitempty"`
+	MD5             string `json:"md5,omitempty"`
+	ShareKind       string `json:"shareKind,omitempty"`
+	SystemKind      string `json:"systemKind,omitempty"`
+	RevokedBy       string `json:"revokedBy,omitempty"`
+	Inviter         string `json:"inviter,omitempty"`
+	CDNUrl          string `json:"cdnUrl,omitempty"`
+	Source          string `json:"source,omitempty"`
+	Address         string `json:"address,omitempty"`
+	Latitude        string `json:"lat,omitempty"`
+	Longitude       string `json:"lng,omitempty"`
+	Caption         string `json:"caption,omitempty"`
+	StickerPack     string `json:"stickerPack,omitempty"`
+	StickerAuthor   string `json:"stickerAuthor,omitempty"`
+	VoiceFormat     string `json:"voiceFormat,omitempty"`
+	Played          *bool  `json:"played,omitempty"`
+	SourceXML       string `json:"_source,omitempty"`
+	OriginalContent string `json:"_original,omitempty"`
+	Forwarded       bool   `json:"forwarded,omitempty"`
+	Animated        bool   `json:"animated,omitempty"`
+	Direction       string `json:"direction,omitempty"`
+	Lang            string `json:"lang,omitempty"`
+	Encrypted       bool   `json:"encrypted,omitempty"`
+	Bot             bool   `json:"bot,omitempty"`
+	MentionsAll     bool   `json:"mentionsAll,omitempty"`
+	FileSize        int64  `json:"fileSize,omitempty"`
+	ReplyDepth      int    `json:"replyDepth,omitempty"`
+
+	Joined    []string          `json:"joined,omitempty"`
+	Share     *ChatLabShare     `json:"share,omitempty"`
+	Payment   *ChatLabPayment   `json:"payment,omitempty"`
+	RefundOf  *int              `json:"refundOf,omitempty"`
+	Contact   *ChatLabContact   `json:"contact,omitempty"`
+	ReplyTo   *ChatLabReplyTo   `json:"replyTo,omitempty"`
+	Mentions  []string          `json:"mentions,omitempty"`
+	Reactions []ChatLabReaction `json:"reactions,omitempty"`
+	Children  []ChatLabMessage  `json:"children,omitempty"`
+	Edits     []ChatLabEdit     `json:"edits,omitempty"`
+}
+
+// ChatLabEdit is a prior version of an edited message; Content holds the latest
+type ChatLabEdit struct {
+	Timestamp int64  `json:"timestamp"`
+	Content   string `json:"content"`
+}
+
+// ChatLabContact holds the details of a contact card (名片).
+// Avatar is the local path resolved from AvatarURL and is omitted when unresolved.
+type ChatLabContact struct {
+	Username  string `json:"username,omitempty"`
+	Nickname  string `json:"nickname,omitempty"`
+	Alias     string `json:"alias,omitempty"`
+	AvatarURL string `json:"avatarUrl,omitempty"`
+	Avatar    string `json:"avatar,omitempty"`
+}
+
+// ChatLabReaction is an emoji reaction and the PlatformIDs of the members who left it
+type ChatLabReaction struct {
+	Emoji string   `json:"emoji"`
+	By    []string `json:"by,omitempty"`
+}
+
+// ChatLabShare holds the card details of link / music / mini program shares.
+// PublishedAt is an article's publish time in the export's timestamp unit.
+type ChatLabShare struct {
+	Title       string `json:"title,omitempty"`
+	Desc        string `json:"desc,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Thumb       string `json:"thumb,omitempty"`
+	PublishedAt int64  `json:"publishedAt,omitempty"`
+}
+
+// ConvertOptions controls how internal Messages are converted to ChatLab format.
+// Use DefaultConvertOptions as a starting point; the zero value is not the default.
+type ConvertOptions struct {
+	// Platform is written to Meta.Platform; empty means "wechat"
+	Platform string
+
+	// SelfName is the account name used for the owner's messages when they carry
+	// no sender name. Empty selects the platform default (see DefaultSelfName)
+	SelfName string
+
+	// IncludeSelf keeps messages sent by the owner; when false they are
+	// dropped and the owner is not listed as a member
+	IncludeSelf bool
+
+	// AlwaysUseSelfName uses the self name for every owner message, even when
+	// one sent from another device carries its own sender name
+	AlwaysUseSelfName bool
+
+	// SelfID marks messages from this sender as the owner's, for exports whose
+	// source rows carry no self flag
+	SelfID string
+
+	// SynthesizeMissingIDs keys senders with no platform ID but a display
+	// name as "name:<SenderName>", so they do not collapse into one
+	// empty-keyed member
+	SynthesizeMissingIDs bool
+
+	// ContentRegex keeps only messages whose mapped Content matches, so media
+	// placeholders such as "[图片]" can be matched too. It is checked before
+	// OmitMediaPlaceholder clears Content. Members are limited to the
+	// remaining senders
+	ContentRegex *regexp.Regexp
+
+	// ResolveMD5 maps an image or sticker md5 to a local path; optional.
+	// It is also consulted with contact-card avatar URLs
+	ResolveMD5 func(md5 string) (path string, ok bool)
+
+	// DecryptDat maps an encrypted WeChat image (a .dat file, or a v4 path
+	// without extension) to a decrypted file, typically via dat2img.Dat2Image
+	// which also detects the image format. Images left encrypted (no resolver
+	// or it failed) are marked Encrypted
+	DecryptDat func(datPath string) (path string, err error)
+
+	// TranscodeVoice converts a voice file of the given VoiceFormat ("" when
+	// unknown) to a playable one and returns its path and format; an empty
+	// format is then guessed from the extension. The voice is Contents["path"]
+	// when set, otherwise the silk media key in Contents["voice"] that
+	// the database serves via GetMedia("voice", key). Without it, or when it fails, voice messages
+	// keep the original path or placeholder
+	TranscodeVoice func(path, format string) (outPath, outFormat string, err error)
+
+	// SortMembers orders members by PlatformID instead of first appearance.
+	// Either way the member order is deterministic for diff-friendly output
+	SortMembers bool
+
+	// EmbedMedia inlines media files as data: URIs using LoadMedia, which
+	// returns the file bytes and MIME type. Files larger than MaxEmbedBytes
+	// (DefaultMaxEmbedBytes when <= 0) keep their path reference
+	EmbedMedia    bool
+	LoadMedia     func(ref string) ([]byte, string, error)
+	MaxEmbedBytes int
+
+	// MediaBaseURL rewrites local media paths in Content and Thumb to
+	// MediaBaseURL + "/" + the file name, for viewers serving media from a
+	// CDN. md5s, URLs and embedded data: URIs are left alone
+	MediaBaseURL string
+
+	// Placeholders overrides the Chinese bracket labels used for unresolved
+	// media and titleless shares, keyed by ChatLab type (e.g. "[Image]" for
+	// ChatLabTypeImage)
+	Placeholders map[int]string
+
+	// DropEmpty skips text and unrecognised messages whose content is blank.
+	// Media messages always keep at least a placeholder and are never dropped
+	DropEmpty bool
+
+	// NormalizeSystemSender attributes system messages to a synthetic member
+	// with PlatformID ChatLabSystemSenderID and AccountName SystemName
+	// (DefaultSystemName when empty)
+	NormalizeSystemSender bool
+	SystemName            string
+
+	// IncludeSource attaches the original appmsg XML to share-derived messages
+	// as SourceXML, so the parsed fields can be verified against the source
+	IncludeSource bool
+
+	// NormalizeContent cleans text and reply content: CRLF line endings become
+	// LF, zero-width spaces and BOMs are removed and trailing spaces are trimmed
+	// from each line. With KeepOriginalContent the untouched text is kept in
+	// OriginalContent whenever normalization changed it
+	NormalizeContent    bool
+	KeepOriginalContent bool
+
+	// UnescapeHTML decodes HTML entities such as "&amp;" in text and reply
+	// content, before NormalizeContent. Share URLs and other fields are left
+	// as they are; KeepOriginalContent applies here too
+	UnescapeHTML bool
+
+	// DedupeSyncDuplicates drops copies of a message created by multi-device
+	// sync: same sender, second, type and content but a different local ID.
+	// The first copy is kept
+	DedupeSyncDuplicates bool
+
+	// MinContentRunes drops text messages shorter than this many runes
+	// (after trimming whitespace); media and other types are exempt
+	MinContentRunes int
+
+	// ExpandForwards converts the items of merge-forward messages into Children
+	ExpandForwards bool
+
+	// LastN keeps only the most recent N messages after filtering; 0 keeps all
+	LastN int
+
+	// IncludeReplyDepth sets ReplyDepth on replies by following ReplyTo through
+	// the converted batch, for indenting threads
+	IncludeReplyDepth bool
+
+	// PairRefunds links transfer refunds to the transfer they return by
+	// setting RefundOf to its index in Messages (see pairRefunds); both
+	// messages are kept
+	PairRefunds bool
+
+	// AssignSeq numbers output messages from 1 in chronological order
+	AssignSeq bool
+
+	// Reverse emits messages newest first. Seq stays chronological unless
+	// ReverseSeq is set, in which case it follows the reversed output order
+	Reverse    bool
+	ReverseSeq bool
+
+	// DetectLang, when set, tags text and reply messages with the language code
+	// it returns for their content (empty leaves Lang unset)
+	DetectLang func(text string) string
+
+	// IncludeDirection sets Direction on every message: DirectionOut for the
+	// owner's messages and DirectionIn for everyone else's
+	IncludeDirection bool
+
+	// Now is the clock used for ExportedAt; nil means time.Now
+	Now func() time.Time
+
+	// TimestampUnit sets the scale of message timestamps and ExportedAt:
+	// TimestampUnitSeconds (default, what the ChatLab spec requires) or
+	// TimestampUnitMillis for consumers expecting millisecond epochs. A
+	// millisecond export records it in ChatLabHeader.TimestampUnit, which the
+	// ChatLab helpers read
+	TimestampUnit string
+
+	// DualTimestamps adds RFC 3339 strings (timestampISO, exportedAtISO) next
+	// to every numeric message timestamp and ExportedAt
+	DualTimestamps bool
+
+	// NormalizeTimestamps treats source times after year 2100 as millisecond
+	// values misread as seconds and scales them back; on by default
+	NormalizeTimestamps bool
+
+	// ResolveAvatar maps a member or group ID to its avatar URL or local path
+	// for member avatars and Meta.GroupAvatar; optional. An empty result
+	// leaves no avatar
+	ResolveAvatar func(platformID string) string
+
+	// AvatarBaseDir, when set, normalizes member avatars with NormalizeAvatar;
+	// avatars that fail to normalize are kept as they are
+	AvatarBaseDir string
+
+	// StripAvatars clears member avatars, Meta.GroupAvatar and contact-card
+	// avatar links to keep exports small; unlike anonymization, names and IDs
+	// are left untouched
+	StripAvatars bool
+
+	// OmitMediaPlaceholder clears Content of payment, location, contact and
+	// link messages whose structured fields (Payment, coordinates, Contact,
+	// Share URL) already carry it. Text, unresolved media and locations named
+	// by a POI or label keep Content
+	OmitMediaPlaceholder bool
+
+	// InlineQuotes prefixes reply Content with "> sender: quote\n" for
+	// consumers that ignore ReplyTo; quotes are cut to InlineQuoteRunes
+	// (DefaultInlineQuoteRunes when 0). ReplyTo is still emitted
+	InlineQuotes     bool
+	InlineQuoteRunes int
+
+	// IncludeSummary computes a Summary of the converted conversation
+	IncludeSummary bool
+
+	// IncludeChecksum stores a SHA-256 over the messages in the header; see
+	// ChatLab.VerifyChecksum
+	IncludeChecksum bool
+
+	// Stats, when non-nil, is reset and filled with conversion statistics
+	Stats *ConvertStats
+
+	// Progress, when non-nil, is called synchronously with the number of input
+	// messages processed after every ProgressEvery messages
+	// (DefaultProgressEvery when <= 0) and once at the end
+	Progress      func(done, total int)
+	ProgressEvery int
+}
+
+// Message directions for ConvertOptions.IncludeDirection
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// Timestamp units for ConvertOptions.TimestampUnit
+const (
+	TimestampUnitSeconds = "s"
+	TimestampUnitMillis  = "ms"
+)
+
+// ChatLabSystemSenderID is the PlatformID of the synthetic system member
+const ChatLabSystemSenderID = "__system__"
+
+// DefaultSystemName is the AccountName of the synthetic system member
+const DefaultSystemName = "系统"
+
+// DefaultProgressEvery is how many messages pass between Progress calls
+const DefaultProgressEvery = 1000
+
+// DefaultDerivedNameMembers is how many member names title an unnamed group
+const DefaultDerivedNameMembers = 3
+
+// SynthesizedIDPrefix marks sender IDs made up by SynthesizeMissingIDs
+const SynthesizedIDPrefix = "name:"
+
+// ConvertStats reports what a conversion kept and dropped
+type ConvertStats struct {
+	Input        int // 输入消息数
+	Output       int // 输出消息数
+	DroppedEmpty int // DropEmpty 丢弃的空白消息数
+	DroppedShort int // MinContentRunes 丢弃的过短消息数
+	DroppedSync  int // DedupeSyncDuplicates 丢弃的多端同步重复消息数
+	DroppedRegex int // ContentRegex 丢弃的不匹配消息数
+
+	// UnmappedTypes 计数落入 ChatLabTypeOther 的消息，键为源类型
+	// SubType<<32 | Type（与数据库中的打包方式一致）
+	UnmappedTypes map[int]int
+}
+
+// DefaultConvertOptions returns the options used by ConvertToChatLab
+func DefaultConvertOptions() ConvertOptions {
+	return ConvertOptions{
+		Platform:            "wechat",
+		IncludeSelf:         true,
+		NormalizeTimestamps: true,
+	}
+}
+
+// timestamp renders t in the configured TimestampUnit, applying NormalizeTimestamps
+func (o ConvertOptions) timestamp(t time.Time) int64 {
+	t = o.normalizeTime(t)
+	if o.TimestampUnit == TimestampUnitMillis {
+		return t.UnixMilli()
+	}
+	return t.Unix()
+}
+
+// timestampISO renders t as RFC 3339 at the precision of TimestampUnit
+func (o ConvertOptions) timestampISO(t time.Time) string {
+	t = o.normalizeTime(t)
+	if o.TimestampUnit == TimestampUnitMillis {
+		return t.Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	return t.Format(time.RFC3339)
+}
+
+// millis reports whether cl's timestamps are in milliseconds
+func (cl ChatLab) millis() bool {
+	return cl.ChatLab.TimestampUnit == TimestampUnitMillis
+}
+
+// timeOf converts a timestamp of cl to a time.Time
+func (cl ChatLab) timeOf(ts int64) time.Time {
+	if cl.millis() {
+		return time.UnixMilli(ts)
+	}
+	return time.Unix(ts, 0)
+}
+
+// timestampOf converts t to a timestamp in cl's unit
+func (cl ChatLab) timestampOf(t time.Time) int64 {
+	if cl.millis() {
+		return t.UnixMilli()
+	}
+	return t.Unix()
+}
+
+// timestampSpan converts d to a difference between timestamps of cl
+func (cl ChatLab) timestampSpan(d time.Duration) int64 {
+	if cl.millis() {
+		return d.Milliseconds()
+	}
+	return int64(d / time.Second)
+}
+
+// durationOf converts a difference between timestamps of cl to a duration
+func (cl ChatLab) durationOf(span int64) time.Duration {
+	if cl.millis() {
+		return time.Duration(span) * time.Millisecond
+	}
+	return time.Duration(span) * time.Second
+}
+
+// normalizeTime applies NormalizeTimestamps
+func (o ConvertOptions) normalizeTime(t time.Time) time.Time {
+	if o.NormalizeTimestamps && t.Year() > 2100 {
+		return time.UnixMilli(t.Unix())
+	}
+	return t
+}
+
+// now reads the configured clock
+func (o ConvertOptions) now() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// mediaCaption reads the caption sent along with an image or video
+func mediaCaption(contents map[string]interface{}) string {
+	if caption := contentsString(contents, "caption"); caption != "" {
+		return caption
+	}
+	return contentsString(contents, "text")
+}
+
+// placeholder returns the Placeholders override for ChatLab type t, or def
+func (o ConvertOptions) placeholder(t int, def string) string {
+	if p, ok := o.Placeholders[t]; ok {
+		return p
+	}
+	return def
+}
+
+// isEncryptedImagePath reports whether an image path is an encrypted WeChat
+// image: a .dat file, or a v4 image stored under its md5 with no extension.
+// It matches the rule the HTTP image handler uses.
+func isEncryptedImagePath(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == "" || strings.EqualFold(ext, ".dat")
+}
+
+// decryptDat resolves an encrypted image path, reporting whether it stays encrypted
+func (o ConvertOptions) decryptDat(datPath string) (string, bool) {
+	if o.DecryptDat == nil {
+		return datPath, true
+	}
+	path, err := o.DecryptDat(datPath)
+	if err != nil || path == "" {
+		return datPath, true
+	}
+	return path, false
+}
+
+// Voice formats reported in ChatLabMessage.VoiceFormat. AMR is only ever
+// reported by a transcoder, as WeChat also stores silk audio in .amr files
+const (
+	VoiceFormatSilk = "silk"
+	VoiceFormatAMR  = "amr"
+	VoiceFormatMP3  = "mp3"
+	VoiceFormatWAV  = "wav"
+	VoiceFormatOGG  = "ogg"
+	VoiceFormatM4A  = "m4a"
+)
+
+// voiceFormat tags a voice file by extension, returning "" when the extension
+// does not identify the format (including .amr, see VoiceFormatAMR)
+func voiceFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".silk", ".slk":
+		return VoiceFormatSilk
+	case ".mp3":
+		return VoiceFormatMP3
+	case ".wav":
+		return VoiceFormatWAV
+	case ".ogg", ".opus":
+		return VoiceFormatOGG
+	case ".m4a":
+		return VoiceFormatM4A
+	}
+	return ""
+}
+
+// transcodeVoice returns the playable path and format of a voice reference,
+// falling back to the original when there is no transcoder or it fails
+func (o ConvertOptions) transcodeVoice(path, format string) (string, string) {
+	if o.TranscodeVoice == nil || format == VoiceFormatMP3 {
+		return path, format
+	}
+	out, outFormat, err := o.TranscodeVoice(path, format)
+	if err != nil || out == "" {
+		return path, format
+	}
+	if outFormat == "" {
+		outFormat = voiceFormat(out)
+	}
+	return out, outFormat
+}
+
+// ConversationID is a stable key for a conversation derived from its platform
+// and talker ID only, so renames keep the same ID: the first 16 bytes of
+// SHA-256("platform\x00talkerID") in hex
+func ConversationID(platform, talkerID string) string {
+	sum := sha256.Sum256([]byte(platform + "\x00" + talkerID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// DefaultSelfName returns the owner's display name used for platform
+func DefaultSelfName(platform string) string {
+	switch platform {
+	case "", "wechat":
+		return "我"
+	}
+	return "Me"
+}
+
+// platform returns the configured platform, defaulting to wechat
+func (o ConvertOptions) platform() string {
+	if o.Platform == "" {
+		return "wechat"
+	}
+	return o.Platform
+}
+
+// selfName returns the configured self name or the platform default
+func (o ConvertOptions) selfName() string {
+	if o.SelfName != "" {
+		return o.SelfName
+	}
+	return DefaultSelfName(o.platform())
+}
+
+// contentsString returns the string stored under key, or "" when Contents is
+// nil, the key is absent or the value is not a string
+func contentsString(contents map[string]interface{}, key string) string {
+	if contents == nil {
+		return ""
+	}
+	v, _ := contents[key].(string)
+	return v
+}
+
+// contentsStringOr is contentsString with a placeholder for missing values
+func contentsStringOr(contents map[string]interface{}, key, placeholder string) string {
+	if v := contentsString(contents, key); v != "" {
+		return v
+	}
+	return placeholder
+}
+
+// contentsStrings converts a []string or []interface{} value to []string
+func contentsStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// toInt64 converts the numeric types found in decoded contents to int64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// contentsUnix reads a positive unix-seconds value stored as a number or a
+// decimal string
+func contentsUnix(contents map[string]interface{}, key string) (int64, bool) {
+	v, ok := toInt64(contents[key])
+	if !ok {
+		v, _ = strconv.ParseInt(contentsString(contents, key), 10, 64)
+	}
+	return v, v > 0
+}
+
+// parseEdits reads Contents["edits"], a list of {"timestamp": 1703001600, "content": "..."}
+// prior versions in chronological order
+func parseEdits(contents map[string]interface{}) []ChatLabEdit {
+	if contents == nil {
+		return nil
+	}
+	var edits []ChatLabEdit
+	switch v := contents["edits"].(type) {
+	case []ChatLabEdit:
+		edits = append(edits, v...)
+	case []interface{}:
+		for _, item := range v {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ts, _ := toInt64(entry["timestamp"])
+			edits = append(edits, ChatLabEdit{Timestamp: ts, Content: contentsString(entry, "content")})
+		}
+	}
+	return edits
+}
+
+// invisibleReplacer drops zero-width spaces and byte order marks. Zero-width
+// joiners are kept since emoji sequences depend on them
+var invisibleReplacer = strings.NewReplacer("\u200b", "", "\ufeff", "", "\r\n", "\n")
+
+// normalizeContent applies ConvertOptions.NormalizeContent to s
+func normalizeContent(s string) string {
+	s = invisibleReplacer.Replace(s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isAnimatedSticker reports whether a sticker is known to be animated, from
+// Contents["isanimated"], Contents["format"] or a gif/apng file reference
+func isAnimatedSticker(contents map[string]interface{}, refs ...string) bool {
+	switch v := contents["isanimated"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "1" || strings.EqualFold(v, "true")
+	}
+	if format := strings.ToLower(contentsString(contents, "format")); format != "" {
+		return format == "gif" || format == "apng"
+	}
+	for _, ref := range refs {
+		ref = strings.ToLower(ref)
+		if i := strings.IndexAny(ref, "?#"); i >= 0 {
+			ref = ref[:i]
+		}
+		if strings.HasSuffix(ref, ".gif") || strings.HasSuffix(ref, ".apng") || strings.HasPrefix(ref, "data:image/gif") {
+			return true
+		}
+	}
+	return false
+}
+
+// contentsBool reads a flag stored as a bool, a number or a "1"/"0"/"true"/
+// "false" string; ok is false when the key is absent or unrecognised
+func contentsBool(contents map[string]interface{}, key string) (value, ok bool) {
+	switch v := contents[key].(type) {
+	case bool:
+		return v, true
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, true
+		}
+		return false, false
+	}
+	if n, ok := toInt64(contents[key]); ok {
+		return n != 0, true
+	}
+	return false, false
+}
+
+// mentionAllID is the atuserlist marker of an @所有人 mention
+const mentionAllID = "notify@all"
+
+// atUserList reads Contents["atuserlist"], either a list or the
+// comma-separated form used in msgsource
+func atUserList(contents map[string]interface{}) []string {
+	if contents == nil {
+		return nil
+	}
+	var ids []string
+	switch v := contents["atuserlist"].(type) {
+	case string:
+		ids = strings.Split(v, ",")
+	default:
+		ids = contentsStrings(v)
+	}
+	return ids
+}
+
+// mentionsAll reports whether a message mentions everyone, by the atuserlist
+// marker or an "@所有人" in its text
+func mentionsAll(contents map[string]interface{}, text string) bool {
+	if strings.Contains(text, "@所有人") {
+		return true
+	}
+	for _, id := range atUserList(contents) {
+		if strings.TrimSpace(id) == mentionAllID {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMentions reads the individually mentioned platform IDs from
+// Contents["atuserlist"]; the @所有人 marker is reported by mentionsAll instead
+func parseMentions(contents map[string]interface{}) []string {
+	ids := atUserList(contents)
+	mentions := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && id != mentionAllID {
+			mentions = append(mentions, id)
+		}
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	return mentions
+}
+
+// parseReactions reads Contents["reactions"], which is either a list of
+// {"emoji": "👍", "by": ["wxid_a"]} entries or a map of emoji to reactor IDs
+func parseReactions(contents map[string]interface{}) []ChatLabReaction {
+	if contents == nil {
+		return nil
+	}
+	var reactions []ChatLabReaction
+	switch v := contents["reactions"].(type) {
+	case []interface{}:
+		for _, item := range v {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			emoji := contentsString(entry, "emoji")
+			if emoji == "" {
+				continue
+			}
+			by := contentsStrings(entry["by"])
+			if by == nil {
+				by = contentsStrings(entry["users"])
+			}
+			reactions = append(reactions, ChatLabReaction{Emoji: emoji, By: by})
+		}
+	case []ChatLabReaction:
+		reactions = append(reactions, v...)
+	case map[string]interface{}:
+		emojis := make([]string, 0, len(v))
+		for emoji := range v {
+			emojis = append(emojis, emoji)
+		}
+		sort.Strings(emojis)
+		for _, emoji := range emojis {
+			reactions = append(reactions, ChatLabReaction{Emoji: emoji, By: contentsStrings(v[emoji])})
+		}
+	}
+	return reactions
+}
+
+// newChatLabContact builds the contact card details, or nil when the card carries nothing
+func newChatLabContact(contents map[string]interface{}, opts ConvertOptions) *ChatLabContact {
+	contact := &ChatLabContact{
+		Username:  contentsString(contents, "username"),
+		Nickname:  contentsString(contents, "nickname"),
+		Alias:     contentsString(contents, "alias"),
+		AvatarURL: contentsString(contents, "bigheadimgurl"),
+	}
+	if contact.AvatarURL == "" {
+		contact.AvatarURL = contentsString(contents, "smallheadimgurl")
+	}
+	if opts.StripAvatars {
+		contact.AvatarURL = ""
+	} else if path, ok := opts.resolveMD5(contact.AvatarURL); ok {
+		contact.Avatar = path
+	}
+	if *contact == (ChatLabContact{}) {
+		return nil
+	}
+	return contact
+}
+
+// newChatLabShare builds the share card from appmsg contents, or nil when it carries nothing
+func newChatLabShare(contents map[string]interface{}) *ChatLabShare {
+	share := &ChatLabShare{
+		Title:  contentsString(contents, "title"),
+		Desc:   contentsString(contents, "desc"),
+		URL:    contentsString(contents, "url"),
+		Author: contentsString(contents, "author"),
+		Thumb:  contentsString(contents, "thumb"),
+	}
+	if *share == (ChatLabShare{}) {
+		return nil
+	}
+	return share
+}
+
+// resolveMD5 resolves md5 through the configured resolver, if any
+func (o ConvertOptions) resolveMD5(md5 string) (string, bool) {
+	if md5 == "" || o.ResolveMD5 == nil {
+		return "", false
+	}
+	return o.ResolveMD5(md5)
 }
 
 // ConvertToChatLab converts a slice of internal Messages to ChatLab format
 func ConvertToChatLab(messages []*Message, talkerID string, talkerName string) ChatLab {
+	return ConvertToChatLabWithOptions(messages, talkerID, talkerName, DefaultConvertOptions())
+}
+
+// ConvertToChatLabWithOptions converts a slice of internal Messages to ChatLab format using opts
+func ConvertToChatLabWithOptions(messages []*Message, talkerID string, talkerName string, opts ConvertOptions) ChatLab {
+	cl, _ := convertToChatLab(context.Background(), messages, talkerID, talkerName, opts)
+	return cl
+}
+
+// ConvertToChatLabContext is ConvertToChatLab bounded by ctx. The context is
+// checked every contextCheckEvery messages; once it is done the conversion stops
+// and returns the messages converted so far together with ctx.Err().
+func ConvertToChatLabContext(ctx context.Context, messages []*Message, talkerID, talkerName string) (ChatLab, error) {
+	return convertToChatLab(ctx, messages, talkerID, talkerName, DefaultConvertOptions())
+}
+
+// ConvertToChatLabWithOptionsContext is ConvertToChatLabWithOptions bounded
+// by ctx, as in ConvertToChatLabContext
+func ConvertToChatLabWithOptionsContext(ctx context.Context, messages []*Message, talkerID, talkerName string, opts ConvertOptions) (ChatLab, error) {
+	return convertToChatLab(ctx, messages, talkerID, talkerName, opts)
+}
+
+// ConvertToChatLabLimit is ConvertToChatLabWithOptionsContext with a safety
+// limit: an input of more than maxMessages messages is rejected with
+// ErrTooManyMessages before anything is converted; 0 means no limit. Unlike
+// LastN it never truncates.
+func ConvertToChatLabLimit(ctx context.Context, messages []*Message, talkerID, talkerName string, opts ConvertOptions, maxMessages int) (ChatLab, error) {
+	if maxMessages > 0 && len(messages) > maxMessages {
+		return ChatLab{}, fmt.Errorf("%w: %d exceeds limit of %d", ErrTooManyMessages, len(messages), maxMessages)
+	}
+	return convertToChatLab(ctx, messages, talkerID, talkerName, opts)
+}
+
+// ErrTooManyMessages is returned by ConvertToChatLabLimit when the input is
+// over its limit
+var ErrTooManyMessages = errors.New("too many messages")
+
+// contextCheckEvery is how many messages pass between context checks
+const contextCheckEvery = 256
+
+func convertToChatLab(ctx context.Context, messages []*Message, talkerID string, talkerName string, opts ConvertOptions) (ChatLab, error) {
+	now := opts.now()
 	cl := ChatLab{
 		ChatLab: ChatLabHeader{
 			Version:    "0.0.1",
-			ExportedAt: time.Now().Unix(),
+			ExportedAt: opts.timestamp(now),
 			Generator:  "Chatlog",
 		},
 		Meta: ChatLabMeta{
-			Name:     talkerName,
-			Platform: "wechat",
-			Type:     "private",
+			Name:           talkerName,
+			Platform:       opts.platform(),
+			Type:           "private",
+			ConversationID: ConversationID(opts.platform(), talkerID),
 		},
 		Members:  make([]ChatLabMember, 0),
 		Messages: make([]ChatLabMessage, 0, len(messages)),
 	}
 
+	if opts.TimestampUnit == TimestampUnitMillis {
+		cl.ChatLab.TimestampUnit = TimestampUnitMillis
+	}
+	if opts.DualTimestamps {
+		cl.ChatLab.ExportedAtISO = opts.timestampISO(now)
+	}
 	if talkerName == "" {
 		cl.Meta.Name = talkerID
 	}
@@ -97,145 +1007,531 @@ func ConvertToChatLab(messages []*Message, talkerID string, talkerName string) C
 		isGroup = true
 	}
 
-	memberMap := make(map[string]ChatLabMember)
+	stats := opts.Stats
+	if stats == nil {
+		stats = &ConvertStats{}
+	}
+	*stats = ConvertStats{Input: len(messages), UnmappedTypes: make(map[int]int)}
 
-	for _, msg := range messages {
-		// Map Message Type
-		clType := ChatLabTypeText
-		content := msg.Content
-
-		// Refine Content and Type
-		switch msg.Type {
-		case MessageTypeText:
-			clType = ChatLabTypeText
-		case MessageTypeImage:
-			clType = ChatLabTypeImage
-			if path, ok := msg.Contents["path"].(string); ok {
-				content = path
-			} else if md5, ok := msg.Contents["md5"].(string); ok {
-				content = md5
-			} else {
-				content = "[图片]"
-			}
-		case MessageTypeVoice:
-			clType = ChatLabTypeVoice
-			content = "[语音]"
-		case MessageTypeVideo:
-			clType = ChatLabTypeVideo
-			content = "[视频]"
-		case MessageTypeAnimation:
-			clType = ChatLabTypeEmoji
-			if cdnURL, ok := msg.Contents["cdnurl"].(string); ok {
-				content = cdnURL
-			} else {
-				content = "[表情]"
-			}
-		case MessageTypeLocation:
-			clType = ChatLabTypeLocation
-			label, _ := msg.Contents["label"].(string)
-			if label != "" {
-				content = label
-			} else {
-				content = "[位置]"
-			}
-		case MessageTypeCard:
-			clType = ChatLabTypeContact
-			content = "[名片]"
-		case MessageTypeVOIP:
-			clType = ChatLabTypeCall
-			content = "[通话]"
-		case MessageTypeSystem:
-			clType = ChatLabTypeSystem
-		case MessageTypeShare:
-			// Default share type
-			clType = ChatLabTypeShare
-			
-			switch msg.SubType {
-			case MessageSubTypeFile:
-				clType = ChatLabTypeFile
-				if title, ok := msg.Contents["title"].(string); ok {
-					content = title
-				}
-			case MessageSubTypeLink, MessageSubTypeLink2:
-				clType = ChatLabTypeLink
-				if url, ok := msg.Contents["url"].(string); ok {
-					content = url
-				}
-			case MessageSubTypeMergeForward, MessageSubTypeNote, MessageSubTypeChatRoomNotice:
-				clType = ChatLabTypeForward
-				if title, ok := msg.Contents["title"].(string); ok {
-					content = title
-				}
-			case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
-				clType = ChatLabTypeShare
-				if title, ok := msg.Contents["title"].(string); ok {
-					content = title
-				}
-			case MessageSubTypeQuote:
-				clType = ChatLabTypeReply
-				// In ChatLab, content is the reply text. 
-				// Structure for reply is usually just text, but maybe with some ref?
-				// Spec says 25 is REPLY.
-				// We keep the text content as is.
-			case MessageSubTypePat:
-				clType = ChatLabTypePoke
-			case MessageSubTypeMusic:
-				clType = ChatLabTypeShare
-				if url, ok := msg.Contents["url"].(string); ok {
-					content = url
-				}
-			case MessageSubTypePay:
-				clType = ChatLabTypeTransfer
-			case MessageSubTypeRedEnvelope, MessageSubTypeRedEnvelopeCover:
-				clType = ChatLabTypeRedPacket
-				content = "[红包]"
+	selfIDs := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	progressEvery := opts.ProgressEvery
+	if progressEvery <= 0 {
+		progressEvery = DefaultProgressEvery
+	}
+
+	var err error
+	for i, msg := range messages {
+		if i%contextCheckEvery == 0 {
+			if err = ctx.Err(); err != nil {
+				break
 			}
-		default:
-			clType = ChatLabTypeOther
 		}
 
-		// Handle Self Name
-		senderName := msg.SenderName
-		if msg.IsSelf && senderName == "" {
-			senderName = "我"
+		if opts.Progress != nil && i > 0 && i%progressEvery == 0 {
+			opts.Progress(i, len(messages))
 		}
 
-		clMsg := ChatLabMessage{
-			Sender:      msg.Sender,
-			AccountName: senderName,
-			Timestamp:   msg.Time.Unix(),
-			Type:        clType,
-			Content:     content,
+		if opts.SynthesizeMissingIDs && msg.Sender == "" && msg.SenderName != "" {
+			named := *msg
+			named.Sender = SynthesizedIDPrefix + msg.SenderName
+			msg = &named
 		}
 
-		// For groups, we might have group nicknames. 
-		// Internal model has 'SenderName' which is usually the display name in chat (Remark or NickName).
-		// In WeChat, the 'Remark' is personal to the observer, 'NickName' is global. 
-		// Group Alias is specific to the room. 
-		// Our 'SenderName' logic in db/message might already be mixing these. 
-		// We'll map SenderName to AccountName for now.
-		if isGroup {
-			clMsg.GroupNickname = senderName // Assume SenderName is the display name in group
+		if !msg.IsSelf && opts.SelfID != "" && msg.Sender == opts.SelfID {
+			self := *msg
+			self.IsSelf = true
+			msg = &self
+		}
+
+		if msg.IsSelf && !opts.IncludeSelf {
+			continue
+		}
+
+		if opts.DedupeSyncDuplicates {
+			fp := messageFingerprint(msg)
+			if seen[fp] {
+				stats.DroppedSync++
+				continue
+			}
+			seen[fp] = true
+		}
+
+		clMsg := convertMessage(msg, isGroup, opts)
+		if clMsg.Type == ChatLabTypeOther {
+			stats.UnmappedTypes[int(msg.SubType<<32|msg.Type)]++
+		}
+
+		if opts.DropEmpty && strings.TrimSpace(clMsg.Content) == "" && (clMsg.Type == ChatLabTypeText || clMsg.Type == ChatLabTypeOther) {
+			stats.DroppedEmpty++
+			continue
+		}
+
+		if opts.MinContentRunes > 0 && clMsg.Type == ChatLabTypeText && utf8.RuneCountInString(strings.TrimSpace(clMsg.Content)) < opts.MinContentRunes {
+			stats.DroppedShort++
+			continue
+		}
+
+		if opts.ContentRegex != nil && !opts.ContentRegex.MatchString(clMsg.Content) {
+			stats.DroppedRegex++
+			continue
 		}
 
+		opts.omitMediaPlaceholder(&clMsg)
 		cl.Messages = append(cl.Messages, clMsg)
+		if msg.IsSelf {
+			selfIDs[msg.Sender] = true
+		}
+	}
 
-		// Collect Member
-		if _, exists := memberMap[msg.Sender]; !exists {
-			member := ChatLabMember{
-				PlatformID:  msg.Sender,
-				AccountName: senderName,
+	if opts.Progress != nil && err == nil {
+		opts.Progress(len(messages), len(messages))
+	}
+
+	if opts.LastN > 0 && len(cl.Messages) > opts.LastN {
+		cl.Messages = cl.Messages[len(cl.Messages)-opts.LastN:]
+	}
+
+	cl.Members = collectMembers(cl.Messages, selfIDs, isGroup)
+	if opts.ResolveAvatar != nil {
+		for i := range cl.Members {
+			cl.Members[i].Avatar = opts.ResolveAvatar(cl.Members[i].PlatformID)
+		}
+		if isGroup {
+			cl.Meta.GroupAvatar = opts.ResolveAvatar(talkerID)
+		}
+	}
+	switch {
+	case opts.StripAvatars:
+		for i := range cl.Members {
+			cl.Members[i].Avatar = ""
+		}
+		cl.Meta.GroupAvatar = ""
+	case opts.AvatarBaseDir != "":
+		for i := range cl.Members {
+			_ = cl.Members[i].NormalizeAvatar(opts.AvatarBaseDir)
+		}
+		group := ChatLabMember{Avatar: cl.Meta.GroupAvatar}
+		if group.NormalizeAvatar(opts.AvatarBaseDir) == nil {
+			cl.Meta.GroupAvatar = group.Avatar
+		}
+	}
+	if opts.SortMembers {
+		sort.Slice(cl.Members, func(i, j int) bool {
+			return cl.Members[i].PlatformID < cl.Members[j].PlatformID
+		})
+	}
+
+	if isGroup && cl.Meta.Name == talkerID {
+		cl.Meta.Name = cl.DeriveName(DefaultDerivedNameMembers)
+	}
+
+	resolveReplies(cl.Messages)
+	if opts.IncludeReplyDepth {
+		assignReplyDepth(cl.Messages)
+	}
+	if opts.InlineQuotes {
+		for i := range cl.Messages {
+			opts.inlineQuote(&cl.Messages[i])
+		}
+	}
+	if opts.PairRefunds {
+		pairRefunds(cl.Messages, DefaultRefundWindow, cl.millis())
+	}
+	if opts.AssignSeq {
+		assignSeq(cl.Messages)
+	}
+	if opts.Reverse {
+		reverseMessages(cl.Messages)
+		remapRefunds(cl.Messages)
+		if opts.AssignSeq && opts.ReverseSeq {
+			assignSeq(cl.Messages)
+		}
+	}
+	stats.Output = len(cl.Messages)
+
+	if opts.IncludeSummary {
+		cl.Summary = newChatLabSummary(cl)
+	}
+	if opts.IncludeChecksum {
+		cl.ChatLab.Checksum = messagesChecksum(cl.Messages)
+	}
+
+	return cl, err
+}
+
+// assignSeq numbers messages from 1 in slice order
+func assignSeq(messages []ChatLabMessage) {
+	for i := range messages {
+		messages[i].Seq = i + 1
+	}
+}
+
+// reverseMessages reverses messages in place
+func reverseMessages(messages []ChatLabMessage) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// ApplyNameHistory merges historical display names, keyed by PlatformID and
+// ordered oldest first, into member Aliases. Names are deduplicated in order and
+// the current AccountName is never repeated as an alias; members without an
+// AccountName take the most recent historical name.
+func (cl *ChatLab) ApplyNameHistory(history map[string][]string) {
+	for i := range cl.Members {
+		m := &cl.Members[i]
+		names := history[m.PlatformID]
+		if len(names) == 0 {
+			continue
+		}
+		if m.AccountName == "" {
+			for j := len(names) - 1; j >= 0; j-- {
+				if name := strings.TrimSpace(names[j]); name != "" {
+					m.AccountName = name
+					break
+				}
 			}
-			if isGroup {
-				member.GroupNickname = senderName
+		}
+		seen := map[string]bool{m.AccountName: true}
+		aliases := make([]string, 0, len(m.Aliases)+len(names))
+		for _, name := range append(append([]string{}, m.Aliases...), names...) {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
 			}
-			memberMap[msg.Sender] = member
+			seen[name] = true
+			aliases = append(aliases, name)
+		}
+		if len(aliases) > 0 {
+			m.Aliases = aliases
 		}
 	}
+}
 
-	for _, m := range memberMap {
-		cl.Members = append(cl.Members, m)
+// groupNickname returns nickname unless it merely repeats accountName, so the
+// omitempty field is left out instead of duplicating the account name
+func groupNickname(accountName, nickname string) string {
+	if nickname == accountName {
+		return ""
 	}
+	return nickname
+}
 
-	return cl
-}
\ No newline at end of file
+// collectMembers lists the senders of messages in order of first appearance
+func collectMembers(messages []ChatLabMessage, selfIDs map[string]bool, isGroup bool) []ChatLabMember {
+	members := make([]ChatLabMember, 0)
+	seen := make(map[string]bool)
+	for _, msg := range messages {
+		if seen[msg.Sender] {
+			continue
+		}
+		seen[msg.Sender] = true
+		member := ChatLabMember{
+			PlatformID:        msg.Sender,
+			AccountName:       msg.AccountName,
+			IsSelf:            selfIDs[msg.Sender],
+			IsOfficialAccount: isOfficialAccount(msg.Sender),
+		}
+		if isGroup {
+			member.GroupNickname = groupNickname(msg.AccountName, msg.GroupNickname)
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// isOfficialAccount reports whether id is a WeChat official account (公众号)
+func isOfficialAccount(id string) bool {
+	return strings.HasPrefix(id, "gh_")
+}
+
+// ConvertMessage converts a single Message with the full type mapping,
+// self-name handling and group-nickname logic used by ConvertToChatLab.
+// An empty selfName selects the wechat default.
+func ConvertMessage(msg *Message, isGroup bool, selfName string) ChatLabMessage {
+	opts := DefaultConvertOptions()
+	opts.SelfName = selfName
+	return convertMessage(msg, isGroup, opts)
+}
+
+// MapMessageType returns only the ChatLab type and content of msg
+func MapMessageType(msg *Message) (int, string) {
+	clMsg := ChatLabMessage{Content: msg.Content}
+	mapMessage(msg, &clMsg, DefaultConvertOptions())
+	return clMsg.Type, clMsg.Content
+}
+
+// convertMessage converts a single message using opts
+func convertMessage(msg *Message, isGroup bool, opts ConvertOptions) ChatLabMessage {
+	// SenderName is the group display name when the sender set one; the
+	// repository then records their account name separately
+	senderName := msg.SenderName
+	if msg.AccountName != "" {
+		senderName = msg.AccountName
+	}
+
+	// Handle Self Name
+	if msg.IsSelf && (senderName == "" || opts.AlwaysUseSelfName) {
+		senderName = opts.selfName()
+	}
+
+	clMsg := ChatLabMessage{
+		Sender:      msg.Sender,
+		AccountName: senderName,
+		Timestamp:   opts.timestamp(msg.Time),
+		Content:     msg.Content,
+	}
+
+	if opts.DualTimestamps {
+		clMsg.TimestampISO = opts.timestampISO(msg.Time)
+	}
+
+	mapMessage(msg, &clMsg, opts)
+	clMsg.Bot = isOfficialAccount(msg.Sender)
+
+	if (opts.UnescapeHTML || opts.NormalizeContent) && (clMsg.Type == ChatLabTypeText || clMsg.Type == ChatLabTypeReply) {
+		cleaned := clMsg.Content
+		if opts.UnescapeHTML {
+			cleaned = html.UnescapeString(cleaned)
+		}
+		if opts.NormalizeContent {
+			cleaned = normalizeContent(cleaned)
+		}
+		if cleaned != clMsg.Content {
+			if opts.KeepOriginalContent {
+				clMsg.OriginalContent = clMsg.Content
+			}
+			clMsg.Content = cleaned
+		}
+	}
+
+	if opts.DetectLang != nil && (clMsg.Type == ChatLabTypeText || clMsg.Type == ChatLabTypeReply) {
+		clMsg.Lang = opts.DetectLang(clMsg.Content)
+	}
+
+	if opts.IncludeDirection {
+		clMsg.Direction = DirectionIn
+		if msg.IsSelf {
+			clMsg.Direction = DirectionOut
+		}
+	}
+
+	if opts.NormalizeSystemSender && msg.Type == MessageTypeSystem {
+		clMsg.Sender = ChatLabSystemSenderID
+		clMsg.AccountName = opts.SystemName
+		if clMsg.AccountName == "" {
+			clMsg.AccountName = DefaultSystemName
+		}
+	}
+
+	if opts.EmbedMedia && isChatLabMediaType(clMsg.Type) {
+		clMsg.Content = opts.embedMedia(clMsg.Content)
+	}
+
+	if opts.MediaBaseURL != "" && isChatLabMediaType(clMsg.Type) {
+		clMsg.Content = opts.mediaURL(clMsg.Content)
+		clMsg.Thumb = opts.mediaURL(clMsg.Thumb)
+	}
+
+	if clMsg.Type == ChatLabTypeReply {
+		clMsg.ReplyTo = newChatLabReplyTo(msg, opts)
+	}
+
+	clMsg.Mentions = parseMentions(msg.Contents)
+	if clMsg.Type == ChatLabTypeText || clMsg.Type == ChatLabTypeReply {
+		clMsg.MentionsAll = mentionsAll(msg.Contents, clMsg.Content)
+	}
+	clMsg.Reactions = parseReactions(msg.Contents)
+	clMsg.Edits = parseEdits(msg.Contents)
+	for i := range clMsg.Edits {
+		clMsg.Edits[i].Timestamp = opts.timestamp(time.Unix(clMsg.Edits[i].Timestamp, 0))
+	}
+
+	if opts.ExpandForwards && msg.Type == MessageTypeShare && msg.SubType == MessageSubTypeMergeForward && msg.Contents != nil {
+		if recordInfo, ok := msg.Contents["recordInfo"].(*RecordInfo); ok {
+			clMsg.Children = forwardChildren(recordInfo, msg, opts)
+		}
+	}
+
+	if opts.IncludeSource && msg.Type == MessageTypeShare {
+		clMsg.SourceXML = msg.RawContent
+	}
+
+	// For groups, the repository records the sender's room display name
+	// (群昵称) in GroupNickname; it is omitted when it equals the account name
+	if isGroup {
+		clMsg.GroupNickname = groupNickname(clMsg.AccountName, msg.GroupNickname)
+	}
+
+	return clMsg
+}
+
+// omitMediaPlaceholder applies OmitMediaPlaceholder to a converted message.
+// It runs after the content filters so they still see the mapped Content.
+func (o ConvertOptions) omitMediaPlaceholder(msg *ChatLabMessage) {
+	if o.OmitMediaPlaceholder && hasStructuredContent(*msg, o) {
+		msg.Content = ""
+	}
+}
+
+// mapMessage sets the ChatLab type, content and structured fields of clMsg from msg.
+// clMsg.Content must be initialised with msg.Content.
+func mapMessage(msg *Message, clMsg *ChatLabMessage, opts ConvertOptions) {
+	switch msg.Type {
+	case MessageTypeText:
+		clMsg.Type = ChatLabTypeText
+	case MessageTypeImage:
+		clMsg.Type = ChatLabTypeImage
+		clMsg.MD5 = contentsString(msg.Contents, "md5")
+		path := contentsString(msg.Contents, "path")
+		if path == "" {
+			path, _ = opts.resolveMD5(clMsg.MD5)
+		}
+		if path == "" {
+			clMsg.Content = opts.placeholder(ChatLabTypeImage, "[图片]")
+		} else if isEncryptedImagePath(path) {
+			clMsg.Content, clMsg.Encrypted = opts.decryptDat(path)
+		} else {
+			clMsg.Content = path
+		}
+		clMsg.Thumb = contentsString(msg.Contents, "thumbpath")
+		clMsg.Caption = mediaCaption(msg.Contents)
+	case MessageTypeVoice:
+		clMsg.Type = ChatLabTypeVoice
+		clMsg.Content = opts.placeholder(ChatLabTypeVoice, "[语音]")
+		if path := contentsString(msg.Contents, "path"); path != "" {
+			clMsg.Content, clMsg.VoiceFormat = opts.transcodeVoice(path, voiceFormat(path))
+		} else if key := contentsString(msg.Contents, "voice"); key != "" {
+			// Voice stored in the database under its media key is always silk
+			clMsg.VoiceFormat = VoiceFormatSilk
+			if out, format := opts.transcodeVoice(key, VoiceFormatSilk); out != key {
+				clMsg.Content, clMsg.VoiceFormat = out, format
+			}
+		}
+		if played, ok := contentsBool(msg.Contents, "isplayed"); ok {
+			clMsg.Played = &played
+		}
+	case MessageTypeVideo:
+		clMsg.Type = ChatLabTypeVideo
+		clMsg.MD5 = contentsString(msg.Contents, "md5")
+		clMsg.Content = contentsStringOr(msg.Contents, "path", opts.placeholder(ChatLabTypeVideo, "[视频]"))
+		clMsg.Thumb = contentsString(msg.Contents, "thumbpath")
+		clMsg.Caption = mediaCaption(msg.Contents)
+	case MessageTypeAnimation:
+		clMsg.Type = ChatLabTypeEmoji
+		clMsg.MD5 = contentsString(msg.Contents, "md5")
+		clMsg.CDNUrl = contentsString(msg.Contents, "cdnurl")
+		if path, ok := opts.resolveMD5(clMsg.MD5); ok {
+			clMsg.Content = path
+		} else if clMsg.CDNUrl != "" {
+			clMsg.Content = clMsg.CDNUrl
+		} else {
+			clMsg.Content = opts.placeholder(ChatLabTypeEmoji, "[表情]")
+		}
+		clMsg.Animated = isAnimatedSticker(msg.Contents, clMsg.Content, clMsg.CDNUrl)
+		clMsg.StickerPack = contentsString(msg.Contents, "productid")
+		clMsg.StickerAuthor = contentsString(msg.Contents, "author")
+	case MessageTypeLocation:
+		clMsg.Type = ChatLabTypeLocation
+		label := contentsString(msg.Contents, "label")
+		clMsg.Latitude = contentsString(msg.Contents, "x")
+		clMsg.Longitude = contentsString(msg.Contents, "y")
+		if poiName := contentsString(msg.Contents, "poiname"); poiName != "" {
+			clMsg.Content = poiName
+			clMsg.Address = label
+		} else if label != "" {
+			clMsg.Content = label
+		} else {
+			clMsg.Content = opts.placeholder(ChatLabTypeLocation, "[位置]")
+		}
+	case MessageTypeCard:
+		clMsg.Type = ChatLabTypeContact
+		clMsg.Content = opts.placeholder(ChatLabTypeContact, "[名片]")
+		clMsg.Contact = newChatLabContact(msg.Contents, opts)
+	case MessageTypeVOIP:
+		clMsg.Type = ChatLabTypeCall
+		clMsg.Content = opts.placeholder(ChatLabTypeCall, "[通话]")
+	case MessageTypeSystem:
+		clMsg.Type = ChatLabTypeSystem
+		// Some pat notices are delivered as plain system messages
+		if from, to, suffix, ok := parsePat(clMsg.Content); ok {
+			clMsg.Type = ChatLabTypePoke
+			clMsg.PatFrom, clMsg.PatTo, clMsg.PatSuffix = from, to, suffix
+		} else if notice, ok := parseSystemMessage(clMsg.Content); ok {
+			clMsg.SystemKind = notice.Kind
+			switch notice.Kind {
+			case SystemKindRecall:
+				clMsg.Type = ChatLabTypeRecall
+			case SystemKindAdminRevoke:
+				clMsg.Type = ChatLabTypeRecall
+				clMsg.RevokedBy = notice.Actor
+			case SystemKindJoinInvite, SystemKindJoinQR, SystemKindJoinAdmin:
+				clMsg.Inviter = notice.Actor
+				clMsg.Joined = splitNames(notice.Target)
+			}
+		}
+	case MessageTypeShare:
+		// Default share type
+		clMsg.Type = ChatLabTypeShare
+
+		switch msg.SubType {
+		case MessageSubTypeFile:
+			clMsg.Type = ChatLabTypeFile
+			clMsg.Content = contentsStringOr(msg.Contents, "title", opts.placeholder(ChatLabTypeFile, "[文件]"))
+			clMsg.MD5 = contentsString(msg.Contents, "md5")
+			if size, ok := toInt64(msg.Contents["size"]); ok && size > 0 {
+				clMsg.FileSize = size
+			}
+		case MessageSubTypeLink, MessageSubTypeLink2:
+			clMsg.Type = ChatLabTypeLink
+			clMsg.Content = contentsStringOr(msg.Contents, "url", opts.placeholder(ChatLabTypeLink, "[链接]"))
+			if isGroupInviteURL(contentsString(msg.Contents, "url")) {
+				clMsg.Type = ChatLabTypeShare
+				clMsg.ShareKind = ShareKindGroupInvite
+			}
+		case MessageSubTypeMergeForward, MessageSubTypeNote, MessageSubTypeChatRoomNotice:
+			clMsg.Type = ChatLabTypeForward
+			clMsg.Content = contentsStringOr(msg.Contents, "title", opts.placeholder(ChatLabTypeForward, "[合并转发]"))
+		case MessageSubTypeMiniProgram, MessageSubTypeMiniProgram2:
+			clMsg.Type = ChatLabTypeShare
+			clMsg.Content = contentsStringOr(msg.Contents, "title", opts.placeholder(ChatLabTypeShare, "[小程序]"))
+		case MessageSubTypeChannel:
+			clMsg.Type = ChatLabTypeShare
+			clMsg.Content = contentsStringOr(msg.Contents, "title", opts.placeholder(ChatLabTypeShare, "[视频号]"))
+		case MessageSubTypeQuote:
+			clMsg.Type = ChatLabTypeReply
+			// In ChatLab, content is the reply text; the quote goes to ReplyTo.
+			// Spec says 25 is REPLY.
+		case MessageSubTypePat:
+			clMsg.Type = ChatLabTypePoke
+			clMsg.PatFrom, clMsg.PatTo, clMsg.PatSuffix, _ = parsePat(clMsg.Content)
+		case MessageSubTypeMusic:
+			clMsg.Type = ChatLabTypeShare
+			clMsg.Content = contentsStringOr(msg.Contents, "url", opts.placeholder(ChatLabTypeShare, "[音乐]"))
+		case MessageSubTypePay:
+			clMsg.Type = ChatLabTypeTransfer
+			clMsg.Payment = newChatLabPayment(msg.Contents, opts.platform())
+		case MessageSubTypeRedEnvelope, MessageSubTypeRedEnvelopeCover:
+			clMsg.Type = ChatLabTypeRedPacket
+			clMsg.Content = opts.placeholder(ChatLabTypeRedPacket, "[红包]")
+		}
+
+		switch clMsg.Type {
+		case ChatLabTypeLink, ChatLabTypeShare:
+			clMsg.Share = newChatLabShare(msg.Contents)
+			clMsg.Source = contentsString(msg.Contents, "sourcedisplayname")
+			if pub, ok := contentsUnix(msg.Contents, "pubtime"); ok && clMsg.Share != nil {
+				clMsg.Share.PublishedAt = opts.timestamp(time.Unix(pub, 0))
+			}
+		}
+		if clMsg.ShareKind == ShareKindGroupInvite && clMsg.Share != nil {
+			if name := groupInviteName(clMsg.Share.Desc); name != "" {
+				clMsg.Share.Title = name
+			}
+		}
+	default:
+		clMsg.Type = ChatLabTypeOther
+	}
+}
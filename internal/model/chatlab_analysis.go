@@ -0,0 +1,379 @@
+package model
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// URLRef is a URL shared in a conversation
+type URLRef struct {
+	Index  int    `json:"index"`
+	Sender string `json:"sender"`
+	URL    string `json:"url"`
+}
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>"'，。、；！？）】」]+`)
+
+// ExtractURLs returns every URL from share cards and text content, deduplicated
+// by URL and ordered by first occurrence.
+func (cl ChatLab) ExtractURLs() []URLRef {
+	refs := make([]URLRef, 0)
+	seen := make(map[string]bool)
+	add := func(i int, msg ChatLabMessage, url string) {
+		url = strings.TrimRight(url, ".,;:!?)")
+		if url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		refs = append(refs, URLRef{Index: i, Sender: msg.Sender, URL: url})
+	}
+
+	for i, msg := range cl.Messages {
+		if msg.Share != nil && msg.Share.URL != "" {
+			add(i, msg, msg.Share.URL)
+		}
+		switch msg.Type {
+		case ChatLabTypeText, ChatLabTypeReply:
+			for _, url := range urlRegexp.FindAllString(msg.Content, -1) {
+				add(i, msg, url)
+			}
+		}
+	}
+	return refs
+}
+
+// LastMessagePreview renders the final non-system message for a conversation list.
+// Text is shown verbatim and media as bracket labels, truncated to maxRunes
+// (no limit when maxRunes <= 0).
+func (cl ChatLab) LastMessagePreview(maxRunes int) string {
+	for i := len(cl.Messages) - 1; i >= 0; i-- {
+		msg := cl.Messages[i]
+		if msg.Type == ChatLabTypeSystem || msg.Type == ChatLabTypeRecall {
+			continue
+		}
+		return truncateRunes(previewContent(msg), maxRunes)
+	}
+	return ""
+}
+
+// previewContent renders a message as short display text
+func previewContent(msg ChatLabMessage) string {
+	if label, ok := chatLabTypeLabels[msg.Type]; ok {
+		return label
+	}
+	return msg.Content
+}
+
+// truncateRunes shortens s to at most n runes, marking the cut with an ellipsis
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n == 1 {
+		return "…"
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// WithDateDividers returns the messages with a synthetic system message inserted
+// before the first message of each local day in loc (time.Local when nil).
+// Dividers carry SystemKind "date_divider" and the date as Content.
+func (cl ChatLab) WithDateDividers(loc *time.Location) []ChatLabMessage {
+	if loc == nil {
+		loc = time.Local
+	}
+	out := make([]ChatLabMessage, 0, len(cl.Messages)+8)
+	lastDay := ""
+	for _, msg := range cl.Messages {
+		t := cl.timeOf(msg.Timestamp).In(loc)
+		if day := t.Format("2006-01-02"); day != lastDay {
+			lastDay = day
+			start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			out = append(out, ChatLabMessage{
+				Sender:     "系统消息",
+				Timestamp:  cl.timestampOf(start),
+				Type:       ChatLabTypeSystem,
+				Content:    day,
+				SystemKind: SystemKindDateDivider,
+			})
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// DeriveName returns a title for groups without a name, joining the AccountNames
+// of the first maxMembers members (all when <= 0) like "张三、李四、王五".
+// Named conversations return Meta.Name unchanged.
+func (cl ChatLab) DeriveName(maxMembers int) string {
+	if cl.Meta.Name != "" && cl.Meta.Name != cl.Meta.GroupID {
+		return cl.Meta.Name
+	}
+	names := make([]string, 0, maxMembers)
+	for _, m := range cl.Members {
+		if m.AccountName == "" || m.PlatformID == ChatLabSystemSenderID || m.PlatformID == "系统消息" {
+			continue
+		}
+		names = append(names, m.AccountName)
+		if maxMembers > 0 && len(names) == maxMembers {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return cl.Meta.Name
+	}
+	return strings.Join(names, "、")
+}
+
+// MessageGroup is a run of consecutive messages from one sender
+type MessageGroup struct {
+	Sender      string `json:"sender"`
+	AccountName string `json:"accountName"`
+	Indexes     []int  `json:"indexes"`
+}
+
+// GroupConsecutive groups consecutive messages from the same sender sent within
+// gap of the previous one, for bubble-style rendering. System messages always
+// stand alone. The messages are not modified.
+func (cl ChatLab) GroupConsecutive(gap time.Duration) []MessageGroup {
+	groups := make([]MessageGroup, 0)
+	maxGap := cl.timestampSpan(gap)
+	for i, msg := range cl.Messages {
+		system := msg.Type == ChatLabTypeSystem || msg.Type == ChatLabTypeRecall
+		if n := len(groups); n > 0 && !system {
+			last := &groups[n-1]
+			prev := cl.Messages[last.Indexes[len(last.Indexes)-1]]
+			prevSystem := prev.Type == ChatLabTypeSystem || prev.Type == ChatLabTypeRecall
+			if !prevSystem && prev.Sender == msg.Sender && msg.Timestamp-prev.Timestamp <= maxGap {
+				last.Indexes = append(last.Indexes, i)
+				continue
+			}
+		}
+		groups = append(groups, MessageGroup{Sender: msg.Sender, AccountName: msg.AccountName, Indexes: []int{i}})
+	}
+	return groups
+}
+
+// TextCorpus returns the content of text and reply messages, one string per
+// message, for building text corpora. System and recall notices are included
+// only when includeSystem is set; media and blank messages are skipped.
+func (cl ChatLab) TextCorpus(includeSystem bool) []string {
+	corpus := make([]string, 0, len(cl.Messages))
+	for _, msg := range cl.Messages {
+		switch msg.Type {
+		case ChatLabTypeText, ChatLabTypeReply:
+		case ChatLabTypeSystem, ChatLabTypeRecall:
+			if !includeSystem {
+				continue
+			}
+		default:
+			continue
+		}
+		if strings.TrimSpace(msg.Content) != "" {
+			corpus = append(corpus, msg.Content)
+		}
+	}
+	return corpus
+}
+
+// GapInfo is a period with no messages, bounded by the messages at Before
+// and After (indexes into ChatLab.Messages)
+type GapInfo struct {
+	Start  int64 `json:"start"`
+	End    int64 `json:"end"`
+	Before int   `json:"before"`
+	After  int   `json:"after"`
+
+	millis bool // Start and End are in milliseconds
+}
+
+// Duration is the length of the gap
+func (g GapInfo) Duration() time.Duration {
+	if g.millis {
+		return time.Duration(g.End-g.Start) * time.Millisecond
+	}
+	return time.Duration(g.End-g.Start) * time.Second
+}
+
+// CoverageGaps returns the periods longer than threshold between consecutive
+// messages, which often point at missing data in partial exports. Messages
+// are expected in chronological order and are not modified.
+func (cl ChatLab) CoverageGaps(threshold time.Duration) []GapInfo {
+	gaps := make([]GapInfo, 0)
+	maxGap := cl.timestampSpan(threshold)
+	for i := 1; i < len(cl.Messages); i++ {
+		start, end := cl.Messages[i-1].Timestamp, cl.Messages[i].Timestamp
+		if end-start > maxGap {
+			gaps = append(gaps, GapInfo{Start: start, End: end, Before: i - 1, After: i, millis: cl.millis()})
+		}
+	}
+	return gaps
+}
+
+// DefaultSessionGap is the silence that starts a new session in ResponseTimes
+const DefaultSessionGap = 30 * time.Minute
+
+// MessageSession is a run of messages with no silence longer than the gap.
+// Start and End are the first and last timestamps; Indexes point into
+// ChatLab.Messages.
+type MessageSession struct {
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"`
+	Indexes []int `json:"indexes"`
+}
+
+// SegmentSessions splits chronologically ordered messages into sessions,
+// starting a new one after any silence longer than gap. The messages are not
+// modified.
+func (cl ChatLab) SegmentSessions(gap time.Duration) []MessageSession {
+	sessions := make([]MessageSession, 0)
+	maxGap := cl.timestampSpan(gap)
+	for i, msg := range cl.Messages {
+		if n := len(sessions); n > 0 && msg.Timestamp-sessions[n-1].End <= maxGap {
+			last := &sessions[n-1]
+			last.End = msg.Timestamp
+			last.Indexes = append(last.Indexes, i)
+			continue
+		}
+		sessions = append(sessions, MessageSession{Start: msg.Timestamp, End: msg.Timestamp, Indexes: []int{i}})
+	}
+	return sessions
+}
+
+// ResponseStats summarises how quickly a member answers others
+type ResponseStats struct {
+	Count  int           `json:"count"`
+	Mean   time.Duration `json:"mean"`
+	Median time.Duration `json:"median"`
+}
+
+// ResponseTimes measures, per sender, the delay between another member's
+// message and the sender's first message after it, within sessions split by
+// DefaultSessionGap. System and recall notices are ignored, and the first
+// speaker of a session is not counted as responding.
+func (cl ChatLab) ResponseTimes() map[string]ResponseStats {
+	delays := make(map[string][]time.Duration)
+	for _, session := range cl.SegmentSessions(DefaultSessionGap) {
+		prev := -1
+		for _, i := range session.Indexes {
+			msg := cl.Messages[i]
+			if msg.Type == ChatLabTypeSystem || msg.Type == ChatLabTypeRecall {
+				continue
+			}
+			if prev >= 0 && cl.Messages[prev].Sender != msg.Sender {
+				delay := cl.durationOf(msg.Timestamp - cl.Messages[prev].Timestamp)
+				delays[msg.Sender] = append(delays[msg.Sender], delay)
+			}
+			prev = i
+		}
+	}
+
+	stats := make(map[string]ResponseStats, len(delays))
+	for sender, ds := range delays {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		var total time.Duration
+		for _, d := range ds {
+			total += d
+		}
+		median := ds[len(ds)/2]
+		if len(ds)%2 == 0 {
+			median = (ds[len(ds)/2-1] + ds[len(ds)/2]) / 2
+		}
+		stats[sender] = ResponseStats{Count: len(ds), Mean: total / time.Duration(len(ds)), Median: median}
+	}
+	return stats
+}
+
+// InteractionMatrix counts directed interactions between members: m[from][to]
+// is how often from replied to or mentioned to. Self-interactions are ignored.
+func (cl ChatLab) InteractionMatrix() map[string]map[string]int {
+	matrix := make(map[string]map[string]int)
+	add := func(from, to string) {
+		if from == "" || to == "" || from == to {
+			return
+		}
+		if matrix[from] == nil {
+			matrix[from] = make(map[string]int)
+		}
+		matrix[from][to]++
+	}
+	for _, msg := range cl.Messages {
+		if msg.ReplyTo != nil {
+			add(msg.Sender, msg.ReplyTo.Sender)
+		}
+		for _, id := range msg.Mentions {
+			add(msg.Sender, id)
+		}
+	}
+	return matrix
+}
+
+// DefaultSummaryTopSenders is how many senders ChatLabSummary.TopSenders lists
+const DefaultSummaryTopSenders = 5
+
+// ChatLabSummary is an at-a-glance overview of a conversation
+type ChatLabSummary struct {
+	MessageCount int                  `json:"messageCount"`
+	MemberCount  int                  `json:"memberCount"`
+	DateRange    *ChatLabDateRange    `json:"dateRange,omitempty"`
+	TopSenders   []ChatLabSenderCount `json:"topSenders,omitempty"`
+}
+
+// ChatLabDateRange spans the first and last message as RFC 3339 times
+type ChatLabDateRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// ChatLabSenderCount is the number of messages one member sent
+type ChatLabSenderCount struct {
+	Sender      string `json:"sender"`
+	AccountName string `json:"accountName"`
+	Count       int    `json:"count"`
+}
+
+// newChatLabSummary summarizes cl
+func newChatLabSummary(cl ChatLab) *ChatLabSummary {
+	summary := &ChatLabSummary{MessageCount: len(cl.Messages), MemberCount: len(cl.Members)}
+	if len(cl.Messages) == 0 {
+		return summary
+	}
+
+	toTime := func(ts int64) string {
+		return cl.timeOf(ts).Format(time.RFC3339)
+	}
+	start, end := cl.Messages[0].Timestamp, cl.Messages[0].Timestamp
+	counts := make(map[string]*ChatLabSenderCount)
+	order := make([]*ChatLabSenderCount, 0)
+	for _, msg := range cl.Messages {
+		if msg.Timestamp < start {
+			start = msg.Timestamp
+		}
+		if msg.Timestamp > end {
+			end = msg.Timestamp
+		}
+		c, ok := counts[msg.Sender]
+		if !ok {
+			c = &ChatLabSenderCount{Sender: msg.Sender, AccountName: msg.AccountName}
+			counts[msg.Sender] = c
+			order = append(order, c)
+		}
+		c.Count++
+	}
+	summary.DateRange = &ChatLabDateRange{Start: toTime(start), End: toTime(end)}
+
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Count > order[j].Count })
+	if len(order) > DefaultSummaryTopSenders {
+		order = order[:DefaultSummaryTopSenders]
+	}
+	for _, c := range order {
+		summary.TopSenders = append(summary.TopSenders, *c)
+	}
+	return summary
+}
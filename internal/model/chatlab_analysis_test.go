@@ -0,0 +1,239 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChatLabExtractURLs(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeShare, SubType: MessageSubTypeLink, Contents: map[string]interface{}{"title": "文章", "url": "https://mp.weixin.qq.com/s/abc"}},
+		{Sender: "b", Type: MessageTypeText, Content: "看这个 https://example.com/x?y=1，还有 http://go.dev."},
+		{Sender: "c", Type: MessageTypeText, Content: "重复 https://mp.weixin.qq.com/s/abc"},
+		{Sender: "c", Type: MessageTypeText, Content: "没有链接"},
+	}
+
+	refs := ConvertToChatLab(messages, "1@chatroom", "群").ExtractURLs()
+
+	want := []URLRef{
+		{Index: 0, Sender: "a", URL: "https://mp.weixin.qq.com/s/abc"},
+		{Index: 1, Sender: "b", URL: "https://example.com/x?y=1"},
+		{Index: 1, Sender: "b", URL: "http://go.dev"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("got %+v, want %+v", refs, want)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("refs[%d] = %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+}
+
+func TestChatLabWithDateDividers(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Sender: "a", Timestamp: time.Date(2024, 7, 3, 23, 50, 0, 0, loc).Unix(), Content: "晚安"},
+		{Sender: "b", Timestamp: time.Date(2024, 7, 3, 23, 59, 0, 0, loc).Unix(), Content: "晚安"},
+		{Sender: "a", Timestamp: time.Date(2024, 7, 4, 8, 0, 0, 0, loc).Unix(), Content: "早"},
+	}}
+
+	got := cl.WithDateDividers(loc)
+	if len(got) != 5 {
+		t.Fatalf("got %d messages, want 5: %+v", len(got), got)
+	}
+	for _, i := range []int{0, 3} {
+		if got[i].SystemKind != SystemKindDateDivider || got[i].Type != ChatLabTypeSystem {
+			t.Errorf("got[%d] = %+v, want divider", i, got[i])
+		}
+	}
+	if got[0].Content != "2024-07-03" || got[3].Content != "2024-07-04" {
+		t.Errorf("divider contents = %q, %q", got[0].Content, got[3].Content)
+	}
+	if got[3].Timestamp != time.Date(2024, 7, 4, 0, 0, 0, 0, loc).Unix() {
+		t.Errorf("divider timestamp = %d, want local midnight", got[3].Timestamp)
+	}
+	if got[4].Content != "早" || len(cl.Messages) != 3 {
+		t.Errorf("original messages changed")
+	}
+}
+
+func TestChatLabDeriveName(t *testing.T) {
+	messages := make([]*Message, 0)
+	for i, name := range []string{"张三", "李四", "王五", "赵六", "钱七"} {
+		messages = append(messages, &Message{Sender: fmt.Sprintf("wxid_%d", i), SenderName: name, Type: MessageTypeText, Content: "hi"})
+	}
+	messages = append([]*Message{{Sender: "系统消息", Type: MessageTypeSystem, Content: "欢迎加入群聊"}}, messages...)
+
+	cl := ConvertToChatLab(messages, "9999@chatroom", "")
+	if cl.Meta.Name != "张三、李四、王五" {
+		t.Errorf("derived meta name = %q", cl.Meta.Name)
+	}
+
+	cl.Meta.Name = cl.Meta.GroupID
+	if got := cl.DeriveName(0); got != "张三、李四、王五、赵六、钱七" {
+		t.Errorf("DeriveName(0) = %q", got)
+	}
+
+	named := ConvertToChatLab(messages, "9999@chatroom", "技术交流群")
+	if named.Meta.Name != "技术交流群" || named.DeriveName(3) != "技术交流群" {
+		t.Errorf("named group name = %q", named.Meta.Name)
+	}
+}
+
+func TestChatLabGroupConsecutive(t *testing.T) {
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Sender: "a", AccountName: "A", Timestamp: 100, Content: "在吗"},
+		{Sender: "a", AccountName: "A", Timestamp: 130, Content: "有事问你"},
+		{Sender: "b", AccountName: "B", Timestamp: 140, Content: "在"},
+		{Sender: "b", AccountName: "B", Timestamp: 1000, Content: "刚才在忙"},
+		{Sender: "b", AccountName: "B", Timestamp: 1010, Type: ChatLabTypeRecall, Content: "撤回了一条消息"},
+		{Sender: "b", AccountName: "B", Timestamp: 1020, Content: "说吧"},
+	}}
+
+	groups := cl.GroupConsecutive(time.Minute)
+
+	want := [][]int{{0, 1}, {2}, {3}, {4}, {5}}
+	if len(groups) != len(want) {
+		t.Fatalf("got %+v, want indexes %v", groups, want)
+	}
+	for i, g := range groups {
+		if fmt.Sprint(g.Indexes) != fmt.Sprint(want[i]) {
+			t.Errorf("groups[%d].Indexes = %v, want %v", i, g.Indexes, want[i])
+		}
+	}
+	if groups[0].Sender != "a" || groups[0].AccountName != "A" {
+		t.Errorf("groups[0] = %+v", groups[0])
+	}
+	if cl.Messages[1].Content != "有事问你" {
+		t.Error("messages should not be modified")
+	}
+}
+
+func TestChatLabTextCorpus(t *testing.T) {
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Type: ChatLabTypeText, Content: "今天吃什么"},
+		{Type: ChatLabTypeImage, Content: "/data/1.jpg"},
+		{Type: ChatLabTypeSystem, Content: "\"张三\"修改群名为“饭搭子”"},
+		{Type: ChatLabTypeReply, Content: "火锅"},
+		{Type: ChatLabTypeText, Content: "  "},
+		{Type: ChatLabTypeVoice, Content: "[语音]"},
+	}}
+
+	if got, want := cl.TextCorpus(false), []string{"今天吃什么", "火锅"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("TextCorpus(false) = %q, want %q", got, want)
+	}
+	if got := cl.TextCorpus(true); len(got) != 3 || got[1] != "\"张三\"修改群名为“饭搭子”" {
+		t.Errorf("TextCorpus(true) = %q", got)
+	}
+}
+
+func TestChatLabCoverageGaps(t *testing.T) {
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Sender: "a", Timestamp: 1000},
+		{Sender: "b", Timestamp: 1600},
+		{Sender: "a", Timestamp: 1600 + 3*86400},
+		{Sender: "b", Timestamp: 1700 + 3*86400},
+	}}
+
+	gaps := cl.CoverageGaps(24 * time.Hour)
+
+	want := []GapInfo{{Start: 1600, End: 1600 + 3*86400, Before: 1, After: 2}}
+	if fmt.Sprint(gaps) != fmt.Sprint(want) {
+		t.Fatalf("gaps = %+v, want %+v", gaps, want)
+	}
+	if d := gaps[0].Duration(); d != 72*time.Hour {
+		t.Errorf("duration = %v, want 72h", d)
+	}
+	if got := cl.CoverageGaps(10 * time.Minute); len(got) != 1 {
+		t.Errorf("a gap of exactly the threshold should not count: %+v", got)
+	}
+	if got := (ChatLab{}).CoverageGaps(time.Hour); got == nil || len(got) != 0 {
+		t.Errorf("empty chat gaps = %#v, want empty", got)
+	}
+}
+
+func TestChatLabResponseTimes(t *testing.T) {
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Sender: "a", Timestamp: 1000},
+		{Sender: "b", Timestamp: 1060},
+		{Sender: "b", Timestamp: 1070},
+		{Sender: "a", Timestamp: 1100},
+		{Sender: "b", Timestamp: 1400},
+		{Sender: "a", Timestamp: 1400, Type: ChatLabTypeSystem},
+		{Sender: "a", Timestamp: 1410},
+		// a new session: a's first message is not a response to b
+		{Sender: "a", Timestamp: 10000},
+		{Sender: "b", Timestamp: 10120},
+	}}
+
+	if sessions := cl.SegmentSessions(DefaultSessionGap); len(sessions) != 2 || fmt.Sprint(sessions[1].Indexes) != "[7 8]" {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+
+	got := cl.ResponseTimes()
+	want := map[string]ResponseStats{
+		"a": {Count: 2, Mean: 20 * time.Second, Median: 20 * time.Second},
+		"b": {Count: 3, Mean: 160 * time.Second, Median: 120 * time.Second},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ResponseTimes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestChatLabInteractionMatrix(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "@B @C 开会了", Contents: map[string]interface{}{"atuserlist": "b, c"}},
+		quoteMessage("收到", &Message{Sender: "a", Type: MessageTypeText, Content: "@B @C 开会了"}),
+		quoteMessage("我也收到", &Message{Sender: "a", Type: MessageTypeText, Content: "@B @C 开会了"}),
+		quoteMessage("自己回复自己", &Message{Sender: "b", Type: MessageTypeText, Content: "收到"}),
+	}
+	messages[2].Sender = "c"
+	messages[2].Contents["atuserlist"] = []interface{}{"b"}
+
+	matrix := ConvertToChatLab(messages, "1@chatroom", "群").InteractionMatrix()
+
+	want := map[string]map[string]int{
+		"a": {"b": 1, "c": 1},
+		"b": {"a": 1},
+		"c": {"a": 1, "b": 1},
+	}
+	if fmt.Sprint(matrix) != fmt.Sprint(want) {
+		t.Errorf("matrix = %v, want %v", matrix, want)
+	}
+}
+
+func TestConvertToChatLabSummary(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	messages := []*Message{
+		{Time: time.Date(2024, 1, 2, 9, 0, 0, 0, loc), Sender: "a", SenderName: "A", Type: MessageTypeText, Content: "1"},
+		{Time: time.Date(2024, 1, 2, 9, 5, 0, 0, loc), Sender: "b", SenderName: "B", Type: MessageTypeText, Content: "2"},
+		{Time: time.Date(2024, 1, 3, 9, 0, 0, 0, loc), Sender: "b", SenderName: "B", Type: MessageTypeText, Content: "3"},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.IncludeSummary = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	s := cl.Summary
+	if s == nil {
+		t.Fatal("summary missing")
+	}
+	if s.MessageCount != 3 || s.MemberCount != 2 {
+		t.Errorf("counts = %d messages, %d members", s.MessageCount, s.MemberCount)
+	}
+	start, _ := time.Parse(time.RFC3339, s.DateRange.Start)
+	end, _ := time.Parse(time.RFC3339, s.DateRange.End)
+	if !start.Equal(messages[0].Time) || !end.Equal(messages[2].Time) {
+		t.Errorf("date range = %+v", s.DateRange)
+	}
+	want := []ChatLabSenderCount{{Sender: "b", AccountName: "B", Count: 2}, {Sender: "a", AccountName: "A", Count: 1}}
+	if fmt.Sprint(s.TopSenders) != fmt.Sprint(want) {
+		t.Errorf("top senders = %+v, want %+v", s.TopSenders, want)
+	}
+
+	if ConvertToChatLab(messages, "1@chatroom", "群").Summary != nil {
+		t.Error("summary should be omitted by default")
+	}
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Bundle layout written by ExportBundle
+const (
+	BundleJSONFile    = "chatlab.json"
+	BundleMediaDir    = "media"
+	BundleMissingFile = "missing.txt"
+)
+
+// ExportBundle writes a portable archive folder: dir/chatlab.json plus every
+// media reference copied into dir/media via loader, which opens a reference
+// and returns its MIME type (used for the extension; may be empty). Message
+// content and thumbnails are rewritten to the relative "media/..." paths.
+// References the loader cannot open keep their original value and are listed
+// in dir/missing.txt. cl itself is not modified.
+func ExportBundle(dir string, cl ChatLab, loader func(ref string) (io.ReadCloser, string, error)) error {
+	mediaDir := filepath.Join(dir, BundleMediaDir)
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return err
+	}
+
+	out := cl
+	out.Messages = append([]ChatLabMessage(nil), cl.Messages...)
+	copied := make(map[string]string)
+	failed := make(map[string]bool)
+	var missing []string
+	for _, ref := range cl.MediaRefs() {
+		if failed[ref.Ref] {
+			continue
+		}
+		rel, ok := copied[ref.Ref]
+		if !ok {
+			var err error
+			rel, err = copyBundleMedia(mediaDir, ref.Ref, loader)
+			if err != nil {
+				failed[ref.Ref] = true
+				missing = append(missing, ref.Ref)
+				continue
+			}
+			copied[ref.Ref] = rel
+		}
+		if ref.Thumb {
+			out.Messages[ref.Index].Thumb = rel
+		} else {
+			out.Messages[ref.Index].Content = rel
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := os.WriteFile(filepath.Join(dir, BundleMissingFile), []byte(strings.Join(missing, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, BundleJSONFile), data, 0644)
+}
+
+// copyBundleMedia copies one reference into mediaDir, returning its bundle-relative path
+func copyBundleMedia(mediaDir, ref string, loader func(ref string) (io.ReadCloser, string, error)) (string, error) {
+	rc, mimeType, err := loader(ref)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	sum := sha1.Sum([]byte(ref))
+	name := hex.EncodeToString(sum[:8]) + bundleMediaExt(ref, mimeType)
+	f, err := os.Create(filepath.Join(mediaDir, name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return path.Join(BundleMediaDir, name), nil
+}
+
+// bundleMediaExt picks a file extension from the reference, falling back to the MIME type
+func bundleMediaExt(ref, mimeType string) string {
+	p := ref
+	if u, err := url.Parse(ref); err == nil && u.Scheme != "" {
+		p = u.Path
+	}
+	if ext := strings.ToLower(path.Ext(filepath.ToSlash(p))); ext != "" && ext != ".dat" && len(ext) <= 5 {
+		return ext
+	}
+	if mimeType != "" {
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			return exts[0]
+		}
+	}
+	return ""
+}
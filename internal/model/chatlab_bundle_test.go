@@ -0,0 +1,78 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportBundle(t *testing.T) {
+	cl := ChatLab{
+		Meta: ChatLabMeta{Name: "A"},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Type: ChatLabTypeImage, Content: "/data/img/1.jpg", Thumb: "/data/img/1_t.jpg"},
+			{Sender: "a", Type: ChatLabTypeVideo, Content: "/data/video/2.mp4"},
+			{Sender: "a", Type: ChatLabTypeVoice, Content: "/data/voice/gone.silk"},
+			{Sender: "a", Type: ChatLabTypeText, Content: "/data/img/1.jpg"},
+		},
+	}
+	files := map[string]string{"/data/img/1.jpg": "JPEG", "/data/img/1_t.jpg": "THUMB", "/data/video/2.mp4": "MP4"}
+	loader := func(ref string) (io.ReadCloser, string, error) {
+		data, ok := files[ref]
+		if !ok {
+			return nil, "", errors.New("not found")
+		}
+		return io.NopCloser(strings.NewReader(data)), "", nil
+	}
+
+	dir := t.TempDir()
+	if err := ExportBundle(dir, cl, loader); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, BundleJSONFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ChatLab
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(rel string) string {
+		if !strings.HasPrefix(rel, "media/") {
+			t.Errorf("%q is not a media/ reference", rel)
+			return ""
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Error(err)
+		}
+		return string(data)
+	}
+	if got := read(got.Messages[0].Content); got != "JPEG" {
+		t.Errorf("image copy = %q", got)
+	}
+	if got := read(got.Messages[0].Thumb); got != "THUMB" {
+		t.Errorf("thumb copy = %q", got)
+	}
+	if !strings.HasSuffix(got.Messages[1].Content, ".mp4") || read(got.Messages[1].Content) != "MP4" {
+		t.Errorf("video = %q", got.Messages[1].Content)
+	}
+	if got.Messages[2].Content != "/data/voice/gone.silk" {
+		t.Errorf("missing ref rewritten to %q", got.Messages[2].Content)
+	}
+	if got.Messages[3].Content != "/data/img/1.jpg" {
+		t.Errorf("text message rewritten to %q", got.Messages[3].Content)
+	}
+	if missing, _ := os.ReadFile(filepath.Join(dir, BundleMissingFile)); string(missing) != "/data/voice/gone.silk\n" {
+		t.Errorf("missing.txt = %q", missing)
+	}
+	if cl.Messages[0].Content != "/data/img/1.jpg" {
+		t.Error("source ChatLab should not be modified")
+	}
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ChatLab gob cache file layout: chatLabCacheMagic, one version byte, gob payload.
+// Bump chatLabCacheVersion whenever the ChatLab structs change incompatibly.
+const chatLabCacheVersion byte = 2
+
+var chatLabCacheMagic = []byte("CLGOB")
+
+var (
+	ErrChatLabCacheInvalid = errors.New("not a chatlab cache file")
+	ErrChatLabCacheVersion = errors.New("chatlab cache version mismatch")
+)
+
+// chatLabCacheFile is the gob payload. Gob drops zero values even behind
+// pointers, so messages whose Played is &false or RefundOf is &0 are listed
+// by their index path (through Children) and restored on load.
+type chatLabCacheFile struct {
+	ChatLab      ChatLab
+	ZeroPlayed   [][]int
+	ZeroRefundOf [][]int
+}
+
+// findZeroPointers records the paths of messages with zero-valued pointer fields
+func (f *chatLabCacheFile) findZeroPointers(messages []ChatLabMessage, path []int) {
+	for i, msg := range messages {
+		p := append(append([]int(nil), path...), i)
+		if msg.Played != nil && !*msg.Played {
+			f.ZeroPlayed = append(f.ZeroPlayed, p)
+		}
+		if msg.RefundOf != nil && *msg.RefundOf == 0 {
+			f.ZeroRefundOf = append(f.ZeroRefundOf, p)
+		}
+		f.findZeroPointers(msg.Children, p)
+	}
+}
+
+// cachedMessage resolves an index path recorded by findZeroPointers
+func cachedMessage(messages []ChatLabMessage, path []int) (*ChatLabMessage, error) {
+	var msg *ChatLabMessage
+	for _, i := range path {
+		if i < 0 || i >= len(messages) {
+			return nil, ErrChatLabCacheInvalid
+		}
+		msg = &messages[i]
+		messages = msg.Children
+	}
+	if msg == nil {
+		return nil, ErrChatLabCacheInvalid
+	}
+	return msg, nil
+}
+
+// restoreZeroPointers sets the fields listed by findZeroPointers
+func (f *chatLabCacheFile) restoreZeroPointers() error {
+	for _, path := range f.ZeroPlayed {
+		msg, err := cachedMessage(f.ChatLab.Messages, path)
+		if err != nil {
+			return err
+		}
+		played := false
+		msg.Played = &played
+	}
+	for _, path := range f.ZeroRefundOf {
+		msg, err := cachedMessage(f.ChatLab.Messages, path)
+		if err != nil {
+			return err
+		}
+		index := 0
+		msg.RefundOf = &index
+	}
+	return nil
+}
+
+// SaveChatLabCache writes cl to path as a gob cache for fast reloading.
+// The cache is a performance aid only; the JSON export remains canonical.
+func SaveChatLabCache(path string, cl ChatLab) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(chatLabCacheMagic); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.WriteByte(chatLabCacheVersion); err != nil {
+		f.Close()
+		return err
+	}
+	file := chatLabCacheFile{ChatLab: cl}
+	file.findZeroPointers(cl.Messages, nil)
+	if err := gob.NewEncoder(w).Encode(file); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadChatLabCache reads a cache written by SaveChatLabCache, rejecting files
+// with a foreign header or a different cache version.
+func LoadChatLabCache(path string) (ChatLab, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ChatLab{}, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(chatLabCacheMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ChatLab{}, ErrChatLabCacheInvalid
+	}
+	if !bytes.Equal(header[:len(chatLabCacheMagic)], chatLabCacheMagic) {
+		return ChatLab{}, ErrChatLabCacheInvalid
+	}
+	if v := header[len(chatLabCacheMagic)]; v != chatLabCacheVersion {
+		return ChatLab{}, fmt.Errorf("%w: got %d, want %d", ErrChatLabCacheVersion, v, chatLabCacheVersion)
+	}
+
+	var file chatLabCacheFile
+	if err := gob.NewDecoder(r).Decode(&file); err != nil {
+		return ChatLab{}, err
+	}
+	if err := file.restoreZeroPointers(); err != nil {
+		return ChatLab{}, err
+	}
+	// Gob decodes empty slices as nil; the spec requires both arrays
+	cl := file.ChatLab
+	if cl.Members == nil {
+		cl.Members = make([]ChatLabMember, 0)
+	}
+	if cl.Messages == nil {
+		cl.Messages = make([]ChatLabMessage, 0)
+	}
+	return cl, nil
+}
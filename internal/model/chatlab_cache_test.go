@@ -0,0 +1,106 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChatLabCacheRoundTrip(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(1703001600, 0), Sender: "a", SenderName: "张三", Type: MessageTypeText, Content: "你好"},
+		{Time: time.Unix(1703001610, 0), Sender: "b", SenderName: "李四", Type: MessageTypeShare, SubType: MessageSubTypeLink, Contents: map[string]interface{}{"title": "t", "url": "https://example.com"}},
+	}
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	path := filepath.Join(t.TempDir(), "chatlab.gob")
+	if err := SaveChatLabCache(path, cl); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadChatLabCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cl) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got, cl)
+	}
+
+	// Stale cache version
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(chatLabCacheMagic)] = chatLabCacheVersion + 1
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadChatLabCache(path); !errors.Is(err, ErrChatLabCacheVersion) {
+		t.Errorf("stale cache err = %v, want ErrChatLabCacheVersion", err)
+	}
+
+	// Foreign file
+	if err := os.WriteFile(path, []byte(`{"chatlab":{}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadChatLabCache(path); !errors.Is(err, ErrChatLabCacheInvalid) {
+		t.Errorf("foreign file err = %v, want ErrChatLabCacheInvalid", err)
+	}
+}
+
+func TestChatLabCacheZeroPointers(t *testing.T) {
+	played, unplayed, first := true, false, 0
+	cl := ChatLab{
+		ChatLab: ChatLabHeader{Version: "0.0.1"},
+		Members: []ChatLabMember{},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Type: ChatLabTypeTransfer, Content: "[转账]"},
+			{Sender: "b", Type: ChatLabTypeTransfer, Content: "[退还]", RefundOf: &first},
+			{Sender: "a", Type: ChatLabTypeVoice, Played: &unplayed},
+			{Sender: "b", Type: ChatLabTypeVoice, Played: &played},
+			{Sender: "a", Type: ChatLabTypeForward, Children: []ChatLabMessage{{Sender: "c", Type: ChatLabTypeVoice, Played: &unplayed}}},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "chatlab.gob")
+	if err := SaveChatLabCache(path, cl); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadChatLabCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cl) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got.Messages, cl.Messages)
+	}
+	if got.Messages[0].RefundOf != nil || got.Messages[3].Played == nil || !*got.Messages[3].Played {
+		t.Errorf("unset or true fields changed: %+v", got.Messages)
+	}
+}
+
+func TestChatLabCacheEmptyExport(t *testing.T) {
+	cl := ConvertToChatLab(nil, "a", "A")
+
+	path := filepath.Join(t.TempDir(), "chatlab.gob")
+	if err := SaveChatLabCache(path, cl); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadChatLabCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"members":[]`)) || !bytes.Contains(b, []byte(`"messages":[]`)) {
+		t.Errorf("empty export reloaded as %s", b)
+	}
+	if !reflect.DeepEqual(got, cl) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got, cl)
+	}
+}
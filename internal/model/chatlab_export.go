@@ -0,0 +1,288 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GenericChat is a platform-neutral chat schema understood by common third-party viewers
+type GenericChat struct {
+	Title        string               `json:"title"`
+	Participants []GenericParticipant `json:"participants"`
+	Messages     []GenericMessage     `json:"messages"`
+}
+
+type GenericParticipant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	IsMe bool   `json:"is_me,omitempty"`
+}
+
+type GenericMessage struct {
+	SenderID    string              `json:"sender_id"`
+	SenderName  string              `json:"sender_name"`
+	IsFromMe    bool                `json:"is_from_me"`
+	TimestampMs int64               `json:"timestamp_ms"`
+	Text        string              `json:"text"`
+	Attachments []GenericAttachment `json:"attachments,omitempty"`
+}
+
+type GenericAttachment struct {
+	Kind string `json:"kind"`
+	Ref  string `json:"ref,omitempty"`
+}
+
+// genericAttachmentKinds maps ChatLab types carried as attachments
+var genericAttachmentKinds = map[int]string{
+	ChatLabTypeImage:    "image",
+	ChatLabTypeVoice:    "audio",
+	ChatLabTypeVideo:    "video",
+	ChatLabTypeFile:     "file",
+	ChatLabTypeEmoji:    "sticker",
+	ChatLabTypeLink:     "link",
+	ChatLabTypeLocation: "location",
+	ChatLabTypeContact:  "contact",
+}
+
+// ConvertToGenericChatJSON renders an already-converted ChatLab in the generic viewer schema
+func ConvertToGenericChatJSON(cl ChatLab) ([]byte, error) {
+	self := make(map[string]bool)
+	gc := GenericChat{
+		Title:        cl.Meta.Name,
+		Participants: make([]GenericParticipant, 0, len(cl.Members)),
+		Messages:     make([]GenericMessage, 0, len(cl.Messages)),
+	}
+	for _, m := range cl.Members {
+		self[m.PlatformID] = m.IsSelf
+		gc.Participants = append(gc.Participants, GenericParticipant{
+			ID:   m.PlatformID,
+			Name: m.AccountName,
+			IsMe: m.IsSelf,
+		})
+	}
+
+	for _, msg := range cl.Messages {
+		gm := GenericMessage{
+			SenderID:    msg.Sender,
+			SenderName:  msg.AccountName,
+			IsFromMe:    self[msg.Sender],
+			TimestampMs: cl.timeOf(msg.Timestamp).UnixMilli(),
+		}
+		if kind, ok := genericAttachmentKinds[msg.Type]; ok {
+			attachment := GenericAttachment{Kind: kind}
+			if mediaRefKind(msg.Content) != "" {
+				attachment.Ref = msg.Content
+			} else {
+				gm.Text = msg.Content
+			}
+			gm.Attachments = []GenericAttachment{attachment}
+		} else {
+			gm.Text = msg.Content
+		}
+		gc.Messages = append(gc.Messages, gm)
+	}
+
+	return json.Marshal(gc)
+}
+
+// ArchiveChat is the {"name","participants","messages"} schema consumed by
+// chat-archive tools. It has no place for platform IDs, avatars, replies,
+// mentions, reactions, payments or nested forwards, so those are dropped;
+// media messages keep only their content (path or label).
+type ArchiveChat struct {
+	Name         string           `json:"name"`
+	Participants []string         `json:"participants"`
+	Messages     []ArchiveMessage `json:"messages"`
+}
+
+type ArchiveMessage struct {
+	Author  string `json:"author"`
+	Date    string `json:"date"`
+	Content string `json:"content"`
+	Type    string `json:"type"`
+}
+
+// ConvertToArchiveSchema renders an already-converted ChatLab in the archive
+// schema. Authors and participants are display names, dates are RFC 3339 and
+// type is the lowercased ChatLab type name, e.g. "text" or "image".
+func ConvertToArchiveSchema(cl ChatLab) ([]byte, error) {
+	ac := ArchiveChat{
+		Name:         cl.Meta.Name,
+		Participants: make([]string, 0, len(cl.Members)),
+		Messages:     make([]ArchiveMessage, 0, len(cl.Messages)),
+	}
+	for _, m := range cl.Members {
+		name := m.AccountName
+		if name == "" {
+			name = m.PlatformID
+		}
+		ac.Participants = append(ac.Participants, name)
+	}
+	for _, msg := range cl.Messages {
+		ac.Messages = append(ac.Messages, ArchiveMessage{
+			Author:  renderName(msg),
+			Date:    cl.timeOf(msg.Timestamp).Format(time.RFC3339),
+			Content: msg.Content,
+			Type:    strings.ToLower(ChatLabTypeName(msg.Type)),
+		})
+	}
+	return json.Marshal(ac)
+}
+
+// ErrUnmappedType is returned by Retarget when the mapper rejects a message type
+var ErrUnmappedType = errors.New("message type not supported by target mapper")
+
+// TypeMapper maps ChatLab message types to the types of a target platform or viewer
+type TypeMapper interface {
+	// MapType returns the target type of t, or false when it has none
+	MapType(t int) (int, bool)
+}
+
+// TypeMapperFunc adapts a function to TypeMapper
+type TypeMapperFunc func(t int) (int, bool)
+
+func (f TypeMapperFunc) MapType(t int) (int, bool) { return f(t) }
+
+// Retarget relabels an imported export for another platform: Meta.Platform is
+// set and the types of messages, their quotes and forwarded children are
+// re-mapped with typeMapper, keeping all content. On error cl is unchanged.
+func (cl *ChatLab) Retarget(platform string, typeMapper TypeMapper) error {
+	messages := make([]ChatLabMessage, len(cl.Messages))
+	copy(messages, cl.Messages)
+	for i := range messages {
+		if err := retargetMessage(&messages[i], typeMapper); err != nil {
+			return fmt.Errorf("messages[%d]: %w", i, err)
+		}
+	}
+	cl.Meta.Platform = platform
+	cl.Messages = messages
+	return nil
+}
+
+// retargetMessage re-maps one message in place; msg must not share its
+// ReplyTo or Children with the source export
+func retargetMessage(msg *ChatLabMessage, typeMapper TypeMapper) error {
+	t, ok := typeMapper.MapType(msg.Type)
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnmappedType, msg.Type)
+	}
+	msg.Type = t
+	if msg.ReplyTo != nil {
+		reply := *msg.ReplyTo
+		if reply.Type, ok = typeMapper.MapType(reply.Type); !ok {
+			return fmt.Errorf("%w: %d (quoted)", ErrUnmappedType, msg.ReplyTo.Type)
+		}
+		msg.ReplyTo = &reply
+	}
+	if msg.Children != nil {
+		children := make([]ChatLabMessage, len(msg.Children))
+		copy(children, msg.Children)
+		for i := range children {
+			if err := retargetMessage(&children[i], typeMapper); err != nil {
+				return fmt.Errorf("children[%d]: %w", i, err)
+			}
+		}
+		msg.Children = children
+	}
+	return nil
+}
+
+// ExportRoster returns the members as a standalone JSON array, with aliases and
+// avatars, for building an address book without the messages.
+// An export without members yields "[]".
+func ExportRoster(cl ChatLab) ([]byte, error) {
+	members := cl.Members
+	if members == nil {
+		members = []ChatLabMember{}
+	}
+	return json.Marshal(members)
+}
+
+// ChatLabRow is a fully flattened message for bulk database inserts
+type ChatLabRow struct {
+	ConversationName string
+	Platform         string
+	Sender           string
+	AccountName      string
+	GroupNickname    string
+	Timestamp        int64
+	Type             int
+	TypeName         string
+	Content          string
+
+	// 转账
+	PaymentAmount   string
+	PaymentCurrency string
+	PaymentMemo     string
+	PaymentStatus   string
+
+	// 位置
+	LocationName    string
+	LocationAddress string
+	LocationLat     string
+	LocationLng     string
+}
+
+// RowOptions controls how messages are flattened by RowsWithOptions
+type RowOptions struct {
+	// CollapseNewlines replaces line breaks in Content with NewlineSeparator
+	// so each row stays on one line in CSV and table outputs
+	CollapseNewlines bool
+
+	// NewlineSeparator replaces each line break when CollapseNewlines is set;
+	// empty means a single space
+	NewlineSeparator string
+}
+
+// collapseNewlines joins the lines of s with the configured separator
+func (o RowOptions) collapseNewlines(s string) string {
+	if !o.CollapseNewlines || !strings.ContainsAny(s, "\r\n") {
+		return s
+	}
+	sep := o.NewlineSeparator
+	if sep == "" {
+		sep = " "
+	}
+	return strings.NewReplacer("\r\n", sep, "\n", sep, "\r", sep).Replace(s)
+}
+
+// Rows flattens the messages into ChatLabRow values in message order
+func (cl ChatLab) Rows() []ChatLabRow {
+	return cl.RowsWithOptions(RowOptions{})
+}
+
+// RowsWithOptions flattens the messages into ChatLabRow values using opts;
+// the messages themselves are left unchanged
+func (cl ChatLab) RowsWithOptions(opts RowOptions) []ChatLabRow {
+	rows := make([]ChatLabRow, 0, len(cl.Messages))
+	for _, msg := range cl.Messages {
+		row := ChatLabRow{
+			ConversationName: cl.Meta.Name,
+			Platform:         cl.Meta.Platform,
+			Sender:           msg.Sender,
+			AccountName:      msg.AccountName,
+			GroupNickname:    msg.GroupNickname,
+			Timestamp:        msg.Timestamp,
+			Type:             msg.Type,
+			TypeName:         ChatLabTypeName(msg.Type),
+			Content:          opts.collapseNewlines(msg.Content),
+		}
+		if p := msg.Payment; p != nil {
+			row.PaymentAmount = p.Amount
+			row.PaymentCurrency = p.Currency
+			row.PaymentMemo = p.Memo
+			row.PaymentStatus = p.Status
+		}
+		if msg.Type == ChatLabTypeLocation {
+			row.LocationName = row.Content
+			row.LocationAddress = msg.Address
+			row.LocationLat = msg.Latitude
+			row.LocationLng = msg.Longitude
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
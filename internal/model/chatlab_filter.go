@@ -0,0 +1,155 @@
+package model
+
+import (
+	"time"
+)
+
+// FilterDay returns a copy of cl holding only the messages sent on the local
+// calendar day of day in loc (time.Local when nil). Members are limited to that
+// day's senders, and Meta's description notes the day. RefundOf links are
+// remapped to the day's indexes, or cleared when they point at another day,
+// and a summary or header checksum is recomputed over the day's messages.
+func FilterDay(cl ChatLab, day time.Time, loc *time.Location) ChatLab {
+	if loc == nil {
+		loc = time.Local
+	}
+	d := day.In(loc)
+	start := cl.timestampOf(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc))
+	end := cl.timestampOf(time.Date(d.Year(), d.Month(), d.Day()+1, 0, 0, 0, 0, loc))
+
+	out := cl
+	out.Messages = make([]ChatLabMessage, 0)
+	kept := make(map[int]int)
+	senders := make(map[string]bool)
+	for i, msg := range cl.Messages {
+		if msg.Timestamp >= start && msg.Timestamp < end {
+			kept[i] = len(out.Messages)
+			out.Messages = append(out.Messages, msg)
+			senders[msg.Sender] = true
+		}
+	}
+	for i := range out.Messages {
+		if ref := out.Messages[i].RefundOf; ref != nil {
+			if index, ok := kept[*ref]; ok {
+				out.Messages[i].RefundOf = &index
+			} else {
+				out.Messages[i].RefundOf = nil
+			}
+		}
+	}
+
+	out.Members = make([]ChatLabMember, 0, len(senders))
+	for _, m := range cl.Members {
+		if senders[m.PlatformID] {
+			out.Members = append(out.Members, m)
+		}
+	}
+
+	if cl.Summary != nil {
+		out.Summary = newChatLabSummary(out)
+	}
+	if cl.ChatLab.Checksum != "" {
+		out.ChatLab.Checksum = messagesChecksum(out.Messages)
+	}
+
+	note := d.Format("2006-01-02") + " 的聊天记录"
+	if out.ChatLab.Description != "" {
+		note = out.ChatLab.Description + "（" + note + "）"
+	}
+	out.ChatLab.Description = note
+	return out
+}
+
+// ChunkMessages splits cl into consecutive pages of at most size messages for
+// paging APIs. Each page keeps the header and meta, lists only the members
+// who sent a message in it, and drops the whole-conversation summary.
+// RefundOf links pointing outside a page are cleared, and a header checksum
+// is recomputed per page. A size below 1 puts every message on one page; a
+// single page is shaped the same way, and an empty cl still yields one page.
+func ChunkMessages(cl ChatLab, size int) []ChatLab {
+	if size < 1 || size > len(cl.Messages) {
+		size = len(cl.Messages)
+	}
+	if size == 0 {
+		size = 1
+	}
+
+	chunks := make([]ChatLab, 0, (len(cl.Messages)+size-1)/size)
+	for start := 0; start == 0 || start < len(cl.Messages); start += size {
+		end := start + size
+		if end > len(cl.Messages) {
+			end = len(cl.Messages)
+		}
+
+		chunk := cl
+		chunk.Summary = nil
+		chunk.Messages = append([]ChatLabMessage(nil), cl.Messages[start:end]...)
+		senders := make(map[string]bool)
+		for i := range chunk.Messages {
+			senders[chunk.Messages[i].Sender] = true
+			if ref := chunk.Messages[i].RefundOf; ref != nil {
+				if *ref >= start && *ref < end {
+					index := *ref - start
+					chunk.Messages[i].RefundOf = &index
+				} else {
+					chunk.Messages[i].RefundOf = nil
+				}
+			}
+		}
+
+		chunk.Members = make([]ChatLabMember, 0, len(senders))
+		for _, m := range cl.Members {
+			if senders[m.PlatformID] {
+				chunk.Members = append(chunk.Members, m)
+			}
+		}
+		if cl.ChatLab.Checksum != "" {
+			chunk.ChatLab.Checksum = messagesChecksum(chunk.Messages)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// TextOnly returns a copy of cl safe for text-only sharing: text, reply, system
+// and poke messages keep their content, while every other message has its
+// Content replaced with the type's bracket label and all media references
+// (paths, URLs, md5s, thumbnails, cards, share links, locations and forwarded
+// items) removed. Quoted media in replies is replaced with its label too.
+// Members and message order are preserved.
+func (cl ChatLab) TextOnly() ChatLab {
+	out := cl
+	out.Members = append([]ChatLabMember(nil), cl.Members...)
+	out.Messages = make([]ChatLabMessage, len(cl.Messages))
+	for i, msg := range cl.Messages {
+		out.Messages[i] = textOnlyMessage(msg)
+	}
+	return out
+}
+
+// textOnlyMessage strips the media references of one message
+func textOnlyMessage(msg ChatLabMessage) ChatLabMessage {
+	if msg.ReplyTo != nil && !textOnlyType(msg.ReplyTo.Type) {
+		quote := *msg.ReplyTo
+		quote.Content = chatLabTypeLabel(quote.Type)
+		msg.ReplyTo = &quote
+	}
+	if textOnlyType(msg.Type) {
+		return msg
+	}
+	msg.Content = chatLabTypeLabel(msg.Type)
+	msg.Thumb, msg.MD5, msg.CDNUrl, msg.SourceXML = "", "", "", ""
+	msg.Address, msg.Latitude, msg.Longitude = "", "", ""
+	msg.Encrypted, msg.Animated = false, false
+	msg.Share, msg.Contact, msg.Children = nil, nil, nil
+	return msg
+}
+
+// textOnlyType reports whether TextOnly keeps the content of type t
+func textOnlyType(t int) bool {
+	switch t {
+	case ChatLabTypeText, ChatLabTypeReply, ChatLabTypeSystem, ChatLabTypeRecall, ChatLabTypePoke:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterDay(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	cl := ChatLab{
+		ChatLab: ChatLabHeader{Version: "0.0.1", ExportedAt: 1720000000},
+		Members: []ChatLabMember{{PlatformID: "a", AccountName: "A"}, {PlatformID: "b", AccountName: "B"}, {PlatformID: "c", AccountName: "C"}},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Timestamp: time.Date(2024, 7, 3, 23, 59, 59, 0, loc).Unix(), Content: "晚安"},
+			{Sender: "b", Timestamp: time.Date(2024, 7, 4, 0, 0, 0, 0, loc).Unix(), Content: "零点了"},
+			{Sender: "c", Timestamp: time.Date(2024, 7, 4, 23, 59, 0, 0, loc).Unix(), Content: "还没睡"},
+			{Sender: "a", Timestamp: time.Date(2024, 7, 5, 0, 0, 0, 0, loc).Unix(), Content: "早"},
+		},
+	}
+
+	// 2024-07-04 02:00 UTC is still 07-04 in CST, but 16:30 UTC is 07-05.
+	got := FilterDay(cl, time.Date(2024, 7, 4, 2, 0, 0, 0, time.UTC), loc)
+
+	if len(got.Messages) != 2 || got.Messages[0].Content != "零点了" || got.Messages[1].Content != "还没睡" {
+		t.Fatalf("messages = %+v", got.Messages)
+	}
+	if len(got.Members) != 2 || got.Members[0].PlatformID != "b" || got.Members[1].PlatformID != "c" {
+		t.Errorf("members = %+v, want b and c", got.Members)
+	}
+	if got.ChatLab.ExportedAt != 1720000000 {
+		t.Errorf("exportedAt changed to %d", got.ChatLab.ExportedAt)
+	}
+	if got.ChatLab.Description != "2024-07-04 的聊天记录" {
+		t.Errorf("description = %q", got.ChatLab.Description)
+	}
+	if len(cl.Messages) != 4 || len(cl.Members) != 3 {
+		t.Error("source ChatLab should not be modified")
+	}
+
+	next := FilterDay(cl, time.Date(2024, 7, 4, 16, 30, 0, 0, time.UTC), loc)
+	if len(next.Messages) != 1 || next.Messages[0].Content != "早" {
+		t.Errorf("next day messages = %+v", next.Messages)
+	}
+	if got.Summary != nil {
+		t.Errorf("summary should stay absent: %+v", got.Summary)
+	}
+
+	cl.Summary = newChatLabSummary(cl)
+	got = FilterDay(cl, time.Date(2024, 7, 4, 2, 0, 0, 0, time.UTC), loc)
+	if s := got.Summary; s == nil || s.MessageCount != 2 || s.MemberCount != 2 || len(s.TopSenders) != 2 {
+		t.Errorf("day summary = %+v", s)
+	}
+	if cl.Summary.MessageCount != 4 {
+		t.Errorf("source summary changed: %+v", cl.Summary)
+	}
+}
+
+func TestFilterDayRefunds(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	sameDay, otherDay := 1, 0
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Sender: "a", Timestamp: time.Date(2024, 7, 3, 10, 0, 0, 0, loc).Unix(), Type: ChatLabTypeTransfer, Content: "转账1"},
+		{Sender: "a", Timestamp: time.Date(2024, 7, 4, 10, 0, 0, 0, loc).Unix(), Type: ChatLabTypeTransfer, Content: "转账2"},
+		{Sender: "b", Timestamp: time.Date(2024, 7, 4, 11, 0, 0, 0, loc).Unix(), Type: ChatLabTypeTransfer, Content: "退还2", RefundOf: &sameDay},
+		{Sender: "b", Timestamp: time.Date(2024, 7, 4, 12, 0, 0, 0, loc).Unix(), Type: ChatLabTypeTransfer, Content: "退还1", RefundOf: &otherDay},
+	}}
+
+	got := FilterDay(cl, time.Date(2024, 7, 4, 12, 0, 0, 0, loc), loc)
+
+	if len(got.Messages) != 3 {
+		t.Fatalf("messages = %+v", got.Messages)
+	}
+	if ref := got.Messages[1].RefundOf; ref == nil || got.Messages[*ref].Content != "转账2" {
+		t.Errorf("same-day refundOf = %v, want the transfer at 0", ref)
+	}
+	if got.Messages[2].RefundOf != nil {
+		t.Errorf("refund of another day's transfer should be cleared: %v", *got.Messages[2].RefundOf)
+	}
+	if *cl.Messages[2].RefundOf != 1 || cl.Messages[3].RefundOf == nil {
+		t.Error("source RefundOf should not be modified")
+	}
+}
+
+func TestFilterDayChecksum(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	messages := []*Message{
+		{Time: time.Date(2024, 7, 3, 10, 0, 0, 0, loc), Sender: "a", Type: MessageTypeText, Content: "昨天"},
+		{Time: time.Date(2024, 7, 4, 10, 0, 0, 0, loc), Sender: "b", Type: MessageTypeText, Content: "今天"},
+	}
+	opts := DefaultConvertOptions()
+	opts.IncludeChecksum = true
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	got := FilterDay(cl, messages[1].Time, loc)
+	if got.ChatLab.Checksum == cl.ChatLab.Checksum || !got.VerifyChecksum() {
+		t.Errorf("filtered checksum %q does not verify", got.ChatLab.Checksum)
+	}
+	if plain := FilterDay(ConvertToChatLab(messages, "a", "A"), messages[1].Time, loc); plain.ChatLab.Checksum != "" {
+		t.Errorf("checksum should stay opt-in: %q", plain.ChatLab.Checksum)
+	}
+}
+
+func TestChunkMessages(t *testing.T) {
+	refund := 3
+	cl := ChatLab{
+		ChatLab: ChatLabHeader{Version: "0.0.1"},
+		Meta:    ChatLabMeta{Name: "群", Platform: "wechat", Type: "group"},
+		Summary: &ChatLabSummary{MessageCount: 5},
+		Members: []ChatLabMember{{PlatformID: "a"}, {PlatformID: "b"}, {PlatformID: "c"}},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Content: "1"},
+			{Sender: "b", Content: "2"},
+			{Sender: "a", Content: "3"},
+			{Sender: "b", Content: "4"},
+			{Sender: "c", Content: "5", RefundOf: &refund},
+		},
+	}
+
+	chunks := ChunkMessages(cl, 2)
+
+	if len(chunks) != 3 {
+		t.Fatalf("chunks = %d, want 3", len(chunks))
+	}
+	wantMembers := [][]string{{"a", "b"}, {"a", "b"}, {"c"}}
+	for i, chunk := range chunks {
+		if chunk.Meta != cl.Meta || chunk.ChatLab != cl.ChatLab || chunk.Summary != nil {
+			t.Errorf("chunks[%d] header = %+v %+v %+v", i, chunk.ChatLab, chunk.Meta, chunk.Summary)
+		}
+		var ids []string
+		for _, m := range chunk.Members {
+			ids = append(ids, m.PlatformID)
+		}
+		if !reflect.DeepEqual(ids, wantMembers[i]) {
+			t.Errorf("chunks[%d] members = %v, want %v", i, ids, wantMembers[i])
+		}
+	}
+	last := chunks[2].Messages
+	if len(last) != 1 || last[0].Content != "5" || last[0].RefundOf != nil {
+		t.Errorf("final chunk = %+v", last)
+	}
+	if cl.Messages[4].RefundOf == nil {
+		t.Error("input should not be modified")
+	}
+	if got := ChunkMessages(cl, 0); len(got) != 1 || len(got[0].Messages) != 5 || got[0].Summary != nil {
+		t.Errorf("size 0 chunks = %+v", got)
+	}
+
+	// A single page is normalised like the others
+	single := ChunkMessages(ChatLab{Summary: cl.Summary, Members: cl.Members, Messages: cl.Messages[:2]}, 10)
+	if len(single) != 1 || single[0].Summary != nil || len(single[0].Members) != 2 || len(single[0].Messages) != 2 {
+		t.Errorf("single page = %+v", single)
+	}
+	if empty := ChunkMessages(ChatLab{Summary: cl.Summary}, 10); len(empty) != 1 || empty[0].Summary != nil || len(empty[0].Messages) != 0 {
+		t.Errorf("empty pages = %+v", empty)
+	}
+}
+
+func TestChatLabTextOnly(t *testing.T) {
+	cl := ChatLab{
+		Members: []ChatLabMember{{PlatformID: "a", AccountName: "A"}},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Type: ChatLabTypeText, Content: "/home/me/秘密.txt 在这"},
+			{Sender: "a", Type: ChatLabTypeImage, Content: "/home/me/img/1.jpg", Thumb: "/home/me/img/1_t.jpg", MD5: "0123456789abcdef0123456789abcdef"},
+			{Sender: "a", Type: ChatLabTypeLink, Content: "https://example.com/private", Share: &ChatLabShare{Title: "文章", URL: "https://example.com/private"}},
+			{Sender: "a", Type: ChatLabTypeContact, Content: "[名片]", Contact: &ChatLabContact{Username: "wxid_b", Avatar: "/home/me/avatar.jpg"}},
+			{Sender: "a", Type: ChatLabTypeOther, Content: "<xml/>"},
+		},
+	}
+
+	got := cl.TextOnly()
+
+	if !reflect.DeepEqual(got.Messages[0], cl.Messages[0]) {
+		t.Errorf("text message changed: %+v", got.Messages[0])
+	}
+	for i, want := range []string{"[图片]", "[链接]", "[名片]", "[OTHER]"} {
+		msg := got.Messages[i+1]
+		if msg.Content != want || msg.Thumb != "" || msg.MD5 != "" || msg.Share != nil || msg.Contact != nil {
+			t.Errorf("messages[%d] = %+v, want bare %q", i+1, msg, want)
+		}
+	}
+	if len(got.Members) != 1 || got.Members[0].AccountName != "A" {
+		t.Errorf("members = %+v", got.Members)
+	}
+	if cl.Messages[1].Content != "/home/me/img/1.jpg" || cl.Messages[2].Share == nil {
+		t.Error("source ChatLab should not be modified")
+	}
+	if refs := got.MediaRefs(); len(refs) != 0 {
+		t.Errorf("media refs remain: %+v", refs)
+	}
+}
+
+func TestChatLabTextOnlyQuotesAndLocations(t *testing.T) {
+	messages := []*Message{
+		quoteMessage("这张图", &Message{Sender: "b", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "/secret/img.jpg"}}),
+		quoteMessage("同意", &Message{Sender: "b", Type: MessageTypeText, Content: "明天见"}),
+		{Sender: "a", Type: MessageTypeLocation, Contents: map[string]interface{}{"x": "39.9", "y": "116.4", "label": "北京市东城区", "poiname": "天安门"}},
+	}
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+	if q := cl.Messages[0].ReplyTo; q == nil || q.Content != "/secret/img.jpg" {
+		t.Fatalf("quoted image = %+v", q)
+	}
+
+	got := cl.TextOnly()
+
+	if q := got.Messages[0].ReplyTo; q == nil || q.Content != "[图片]" {
+		t.Errorf("image quote = %+v, want [图片]", q)
+	}
+	if q := got.Messages[1].ReplyTo; q == nil || q.Content != "明天见" {
+		t.Errorf("text quote = %+v", q)
+	}
+	if loc := got.Messages[2]; loc.Content != "[位置]" || loc.Address != "" || loc.Latitude != "" || loc.Longitude != "" {
+		t.Errorf("location = %+v", loc)
+	}
+	if b, _ := json.Marshal(got); bytes.Contains(b, []byte("/secret/img.jpg")) {
+		t.Errorf("media path leaked: %s", b)
+	}
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// sourceTimeLayouts are the formats seen in merge-forward <sourcetime> values
+var sourceTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-1-2 15:04:05",
+	"2006-01-02 15:04",
+	"2006-1-2 15:04",
+}
+
+// forwardChildren converts the items of a merge-forward record into ChatLab
+// messages flagged Forwarded. Nested merge-forwards expand recursively.
+func forwardChildren(recordInfo *RecordInfo, parent *Message, opts ConvertOptions) []ChatLabMessage {
+	children := make([]ChatLabMessage, 0, len(recordInfo.DataList.DataItems))
+	for _, item := range recordInfo.DataList.DataItems {
+		child := convertMessage(dataItemMessage(item, parent), false, opts)
+		child.Forwarded = true
+		opts.omitMediaPlaceholder(&child)
+		if opts.InlineQuotes {
+			opts.inlineQuote(&child)
+		}
+		children = append(children, child)
+	}
+	return children
+}
+
+// dataItemMessage rebuilds an internal Message from a merge-forward item so the
+// regular mapping applies to it
+func dataItemMessage(item DataItem, parent *Message) *Message {
+	msg := &Message{
+		Time:       parseSourceTime(item.SourceTime, parent.Time),
+		Sender:     item.SourceName,
+		SenderName: item.SourceName,
+		Type:       MessageTypeText,
+		Content:    item.DataDesc,
+		Contents:   make(map[string]interface{}),
+	}
+
+	switch item.DataType {
+	case "2":
+		msg.Type = MessageTypeImage
+		msg.Contents["md5"] = item.FullMD5
+	case "4":
+		msg.Type = MessageTypeVideo
+		msg.Contents["md5"] = item.FullMD5
+	case "5":
+		msg.Type, msg.SubType = MessageTypeShare, MessageSubTypeLink
+		msg.Contents["title"] = item.DataTitle
+		msg.Contents["desc"] = item.DataDesc
+		msg.Contents["url"] = item.Link
+	case "6":
+		msg.Type = MessageTypeLocation
+		msg.Contents["x"] = item.Location.Lat
+		msg.Contents["y"] = item.Location.Lng
+		msg.Contents["label"] = item.Location.Label
+		if item.Location.PoiName != "" {
+			msg.Contents["poiname"] = item.Location.PoiName
+		}
+	case "8":
+		msg.Type, msg.SubType = MessageTypeShare, MessageSubTypeFile
+		msg.Contents["title"] = item.DataTitle
+		msg.Contents["md5"] = item.FullMD5
+	case "16":
+		msg.Type = MessageTypeCard
+		// The card XML is carried in datadesc; older records only hold the nickname
+		var card Card
+		if err := xml.Unmarshal([]byte(item.DataDesc), &card); err == nil {
+			msg.Contents["username"] = card.Username
+			msg.Contents["nickname"] = card.Nickname
+			if card.Alias != "" {
+				msg.Contents["alias"] = card.Alias
+			}
+			if card.BigHeadImgURL != "" {
+				msg.Contents["bigheadimgurl"] = card.BigHeadImgURL
+			}
+		} else if item.DataDesc != "" {
+			msg.Contents["nickname"] = item.DataDesc
+		}
+	case "17":
+		msg.Type, msg.SubType = MessageTypeShare, MessageSubTypeMergeForward
+		msg.Contents["title"] = item.DataTitle
+		if item.RecordXML != nil {
+			msg.Contents["recordInfo"] = &item.RecordXML.RecordInfo
+		}
+	case "32":
+		msg.Type, msg.SubType = MessageTypeShare, MessageSubTypeMusic
+		msg.Contents["title"] = item.DataTitle
+		msg.Contents["url"] = item.StreamWebURL
+	case "37":
+		msg.Type = MessageTypeAnimation
+	}
+
+	return msg
+}
+
+// parseSourceTime parses a merge-forward item time in local time, falling back to def
+func parseSourceTime(s string, def time.Time) time.Time {
+	for _, layout := range sourceTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	return def
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConvertToChatLabExpandForwards(t *testing.T) {
+	msg := &Message{Time: time.Unix(1703001600, 0), Sender: "a", Type: MessageTypeShare}
+	err := msg.ParseMediaInfo(`<msg><appmsg><type>19</type><title>群聊的聊天记录</title><recorditem><![CDATA[<recordinfo><title>群聊的聊天记录</title><datalist count="2">` +
+		`<dataitem datatype="1"><sourcename>张三</sourcename><sourcetime>2023-12-19 10:00:00</sourcetime><datadesc>看这张图</datadesc></dataitem>` +
+		`<dataitem datatype="2"><sourcename>李四</sourcename><sourcetime>2023-12-19 10:01:00</sourcetime><fullmd5>0123456789abcdef0123456789abcdef</fullmd5></dataitem>` +
+		`</datalist></recordinfo>]]></recorditem></appmsg></msg>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ConvertToChatLab([]*Message{msg}, "a", "A").Messages[0].Children; got != nil {
+		t.Errorf("children without ExpandForwards = %+v", got)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.ExpandForwards = true
+	fwd := ConvertToChatLabWithOptions([]*Message{msg}, "a", "A", opts).Messages[0]
+
+	if fwd.Type != ChatLabTypeForward || fwd.Forwarded {
+		t.Errorf("parent = %+v", fwd)
+	}
+	if len(fwd.Children) != 2 {
+		t.Fatalf("children = %+v, want 2", fwd.Children)
+	}
+	text, image := fwd.Children[0], fwd.Children[1]
+	if text.Type != ChatLabTypeText || text.Content != "看这张图" || text.AccountName != "张三" || !text.Forwarded {
+		t.Errorf("text child = %+v", text)
+	}
+	if image.Type != ChatLabTypeImage || image.MD5 != "0123456789abcdef0123456789abcdef" || !image.Forwarded {
+		t.Errorf("image child = %+v", image)
+	}
+	want := time.Date(2023, 12, 19, 10, 1, 0, 0, time.Local).Unix()
+	if image.Timestamp != want {
+		t.Errorf("image child timestamp = %d, want %d", image.Timestamp, want)
+	}
+}
+
+func TestConvertToChatLabForwardedLocationAndCard(t *testing.T) {
+	msg := &Message{Time: time.Unix(1703001600, 0), Sender: "a", Type: MessageTypeShare}
+	err := msg.ParseMediaInfo(`<msg><appmsg><type>19</type><title>聊天记录</title><recorditem><![CDATA[<recordinfo><datalist count="2">` +
+		`<dataitem datatype="6"><sourcename>张三</sourcename><sourcetime>2023-12-19 10:00:00</sourcetime>` +
+		`<location lat="39.908823" lng="116.397470" scale="15" label="北京市东城区东长安街" poiname="天安门广场" /></dataitem>` +
+		`<dataitem datatype="16"><sourcename>李四</sourcename><sourcetime>2023-12-19 10:01:00</sourcetime>` +
+		`<datadesc>&lt;msg username="wxid_friend" nickname="小明" alias="xiaoming" /&gt;</datadesc></dataitem>` +
+		`</datalist></recordinfo>]]></recorditem></appmsg></msg>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.ExpandForwards = true
+	children := ConvertToChatLabWithOptions([]*Message{msg}, "a", "A", opts).Messages[0].Children
+	if len(children) != 2 {
+		t.Fatalf("children = %+v, want 2", children)
+	}
+
+	loc := children[0]
+	if loc.Type != ChatLabTypeLocation || loc.Content != "天安门广场" || loc.Address != "北京市东城区东长安街" {
+		t.Errorf("location child = %+v", loc)
+	}
+	if loc.Latitude != "39.908823" || loc.Longitude != "116.397470" {
+		t.Errorf("location child coordinates = %q, %q", loc.Latitude, loc.Longitude)
+	}
+
+	card := children[1]
+	if card.Type != ChatLabTypeContact || card.Contact == nil || card.Contact.Username != "wxid_friend" || card.Contact.Nickname != "小明" {
+		t.Errorf("card child = %+v, contact %+v", card, card.Contact)
+	}
+}
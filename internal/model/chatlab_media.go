@@ -0,0 +1,163 @@
+package model
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrAvatarScheme is returned by NormalizeAvatar for URLs it cannot keep or map to a path
+var ErrAvatarScheme = errors.New("unsupported avatar scheme")
+
+// DefaultMaxEmbedBytes is the size cap for ConvertOptions.EmbedMedia
+const DefaultMaxEmbedBytes = 1 << 20
+
+// MediaRef kinds
+const (
+	MediaRefKindPath = "path"
+	MediaRefKindURL  = "url"
+	MediaRefKindMD5  = "md5"
+)
+
+// MediaRef describes a media reference in a ChatLab export that may need fetching.
+type MediaRef struct {
+	Index int    `json:"index"` // 消息在 Messages 中的下标
+	Type  int    `json:"type"`  // ChatLab 消息类型
+	Ref   string `json:"ref"`   // 路径 / URL / md5
+	Kind  string `json:"kind"`  // path / url / md5
+	Thumb bool   `json:"thumb,omitempty"`
+}
+
+var md5Regexp = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// MediaRefs enumerates every media reference in the export, in message order.
+// Thumbnails are listed as separate entries with Thumb set. Files are listed by
+// md5, as their Content is the file name.
+func (cl ChatLab) MediaRefs() []MediaRef {
+	refs := make([]MediaRef, 0)
+	for i, msg := range cl.Messages {
+		if msg.Type == ChatLabTypeFile && msg.MD5 != "" {
+			refs = append(refs, MediaRef{Index: i, Type: msg.Type, Ref: msg.MD5, Kind: MediaRefKindMD5})
+			continue
+		}
+		if !isChatLabMediaType(msg.Type) {
+			continue
+		}
+		if kind := mediaRefKind(msg.Content); kind != "" {
+			refs = append(refs, MediaRef{Index: i, Type: msg.Type, Ref: msg.Content, Kind: kind})
+		} else if msg.MD5 != "" {
+			refs = append(refs, MediaRef{Index: i, Type: msg.Type, Ref: msg.MD5, Kind: MediaRefKindMD5})
+		}
+		if kind := mediaRefKind(msg.Thumb); kind != "" {
+			refs = append(refs, MediaRef{Index: i, Type: msg.Type, Ref: msg.Thumb, Kind: kind, Thumb: true})
+		}
+	}
+	return refs
+}
+
+// isChatLabMediaType reports whether messages of type t reference a media file
+func isChatLabMediaType(t int) bool {
+	switch t {
+	case ChatLabTypeImage, ChatLabTypeVoice, ChatLabTypeVideo, ChatLabTypeEmoji:
+		return true
+	}
+	return false
+}
+
+// hasStructuredContent reports whether a non-text message's details are fully
+// carried by structured fields, making its Content redundant. A location is
+// only redundant when Content is the placeholder, as a POI name or label is
+// carried nowhere else.
+func hasStructuredContent(msg ChatLabMessage, opts ConvertOptions) bool {
+	switch msg.Type {
+	case ChatLabTypeTransfer, ChatLabTypeRedPacket:
+		return msg.Payment != nil
+	case ChatLabTypeLocation:
+		return msg.Latitude != "" && msg.Longitude != "" &&
+			msg.Content == opts.placeholder(ChatLabTypeLocation, "[位置]")
+	case ChatLabTypeContact:
+		return msg.Contact != nil
+	case ChatLabTypeLink, ChatLabTypeShare:
+		return msg.Share != nil && msg.Share.URL != ""
+	}
+	return false
+}
+
+// embedMedia replaces a local path with a data: URI when the file is small enough.
+// Any other reference, or a load failure, returns ref unchanged.
+func (o ConvertOptions) embedMedia(ref string) string {
+	if o.LoadMedia == nil || mediaRefKind(ref) != MediaRefKindPath {
+		return ref
+	}
+	limit := o.MaxEmbedBytes
+	if limit <= 0 {
+		limit = DefaultMaxEmbedBytes
+	}
+	data, mime, err := o.LoadMedia(ref)
+	if err != nil || len(data) > limit {
+		return ref
+	}
+	if mime == "" {
+		mime = http.DetectContentType(data)
+	}
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// mediaURL maps a local path under MediaBaseURL by its file name, escaped for
+// use in a URL; other references are returned unchanged
+func (o ConvertOptions) mediaURL(ref string) string {
+	if mediaRefKind(ref) != MediaRefKindPath {
+		return ref
+	}
+	name := ref[strings.LastIndexAny(ref, `/\`)+1:]
+	return strings.TrimSuffix(o.MediaBaseURL, "/") + "/" + url.PathEscape(name)
+}
+
+// mediaRefKind classifies a reference, returning "" for placeholders such as "[图片]".
+func mediaRefKind(ref string) string {
+	switch {
+	case ref == "" || strings.HasPrefix(ref, "[") || strings.HasPrefix(ref, "data:"):
+		return ""
+	case strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://"):
+		return MediaRefKindURL
+	case md5Regexp.MatchString(ref):
+		return MediaRefKindMD5
+	default:
+		return MediaRefKindPath
+	}
+}
+
+// NormalizeAvatar classifies the member avatar and rewrites local references to
+// absolute paths: http(s) and data: URLs are left alone, file:// URLs become
+// paths, and relative paths are resolved under baseDir. An empty avatar is kept.
+func (m *ChatLabMember) NormalizeAvatar(baseDir string) error {
+	avatar := strings.TrimSpace(m.Avatar)
+	switch {
+	case avatar == "", strings.HasPrefix(avatar, "data:"),
+		strings.HasPrefix(avatar, "http://"), strings.HasPrefix(avatar, "https://"):
+		return nil
+	case strings.HasPrefix(avatar, "file://"):
+		u, err := url.Parse(avatar)
+		if err != nil {
+			return err
+		}
+		avatar = filepath.FromSlash(u.Path)
+	case strings.Contains(avatar, "://"):
+		return fmt.Errorf("%w: %s", ErrAvatarScheme, avatar)
+	}
+
+	if !filepath.IsAbs(avatar) {
+		avatar = filepath.Join(baseDir, avatar)
+	}
+	abs, err := filepath.Abs(avatar)
+	if err != nil {
+		return err
+	}
+	m.Avatar = abs
+	return nil
+}
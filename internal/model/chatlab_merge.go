@@ -0,0 +1,59 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrMergeGroupChat is returned by MergeDirections for group conversations
+var ErrMergeGroupChat = errors.New("merge directions only applies to private chats")
+
+// MergeDirections converts a private chat whose sent and received messages are
+// stored separately. Messages are marked IsSelf by the slice they come from,
+// interleaved by time (sent first on ties) and deduplicated by fingerprint.
+// The input messages are not modified.
+func MergeDirections(sent, received []*Message, talkerID, talkerName string) (ChatLab, error) {
+	if strings.HasSuffix(talkerID, "@chatroom") {
+		return ChatLab{}, ErrMergeGroupChat
+	}
+
+	merged := make([]*Message, 0, len(sent)+len(received))
+	seen := make(map[string]bool)
+	add := func(msgs []*Message, isSelf bool) {
+		for _, msg := range msgs {
+			if msg == nil {
+				continue
+			}
+			fp := messageFingerprint(msg)
+			if seen[fp] {
+				continue
+			}
+			seen[fp] = true
+			m := *msg
+			m.IsSelf = isSelf
+			merged = append(merged, &m)
+		}
+	}
+	add(sent, true)
+	add(received, false)
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+
+	return ConvertToChatLab(merged, talkerID, talkerName), nil
+}
+
+// messageFingerprint identifies a message by sender, second, type and content,
+// ignoring local IDs that differ between copies of the same message
+func messageFingerprint(msg *Message) string {
+	return strings.Join([]string{
+		msg.Sender,
+		strconv.FormatInt(msg.Time.Unix(), 10),
+		strconv.FormatInt(msg.Type, 10),
+		strconv.FormatInt(msg.SubType, 10),
+		msg.Content,
+	}, "\x00")
+}
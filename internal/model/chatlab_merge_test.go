@@ -0,0 +1,71 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMergeDirections(t *testing.T) {
+	sent := []*Message{
+		{Seq: 1, Time: time.Unix(100, 0), Sender: "me", Type: MessageTypeText, Content: "在吗"},
+		{Seq: 3, Time: time.Unix(300, 0), Sender: "me", Type: MessageTypeText, Content: "下午见"},
+	}
+	received := []*Message{
+		{Seq: 2, Time: time.Unix(200, 0), Sender: "a", Type: MessageTypeText, Content: "在"},
+		{Seq: 4, Time: time.Unix(400, 0), Sender: "a", Type: MessageTypeText, Content: "好"},
+		// Overlap: the same sent message also stored with the received ones.
+		{Seq: 9, Time: time.Unix(300, 0), Sender: "me", Type: MessageTypeText, Content: "下午见"},
+	}
+
+	cl, err := MergeDirections(sent, received, "a", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"在吗", "在", "下午见", "好"}
+	if len(cl.Messages) != len(want) {
+		t.Fatalf("messages = %+v, want %v", cl.Messages, want)
+	}
+	for i, content := range want {
+		if cl.Messages[i].Content != content {
+			t.Errorf("messages[%d] = %q, want %q", i, cl.Messages[i].Content, content)
+		}
+	}
+	if len(cl.Members) != 2 || cl.Members[0].PlatformID != "me" || !cl.Members[0].IsSelf || cl.Members[1].IsSelf {
+		t.Errorf("members = %+v, want me (self) and a", cl.Members)
+	}
+	if sent[0].IsSelf {
+		t.Error("input messages should not be modified")
+	}
+
+	if _, err := MergeDirections(sent, received, "1@chatroom", "群"); !errors.Is(err, ErrMergeGroupChat) {
+		t.Errorf("group err = %v, want ErrMergeGroupChat", err)
+	}
+}
+
+func TestConvertToChatLabDedupeSyncDuplicates(t *testing.T) {
+	messages := []*Message{
+		{Seq: 1001, Time: time.Unix(100, 0), Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "到了吗"},
+		{Seq: 1002, Time: time.Unix(100, 0), Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "到了吗"},
+		{Seq: 1003, Time: time.Unix(100, 0), Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "到了吗？"},
+		{Seq: 1004, Time: time.Unix(160, 0), Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "到了吗"},
+	}
+
+	if got := len(ConvertToChatLab(messages, "a", "A").Messages); got != 4 {
+		t.Errorf("default kept %d messages, want 4", got)
+	}
+
+	var stats ConvertStats
+	opts := DefaultConvertOptions()
+	opts.DedupeSyncDuplicates = true
+	opts.Stats = &stats
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if len(cl.Messages) != 3 || cl.Messages[1].Content != "到了吗？" {
+		t.Errorf("messages = %+v", cl.Messages)
+	}
+	if stats.DroppedSync != 1 {
+		t.Errorf("DroppedSync = %d, want 1", stats.DroppedSync)
+	}
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChatLab JSONL line types, see the "_type" field in chatlab.md
+const (
+	ndjsonTypeHeader  = "header"
+	ndjsonTypeMember  = "member"
+	ndjsonTypeMessage = "message"
+)
+
+// maxNDJSONLine bounds a single JSONL line; embedded media can make lines long
+const maxNDJSONLine = 64 << 20
+
+type ndjsonHeader struct {
+	Type    string        `json:"_type"`
+	ChatLab ChatLabHeader `json:"chatlab"`
+	Meta    ChatLabMeta   `json:"meta"`
+}
+
+type ndjsonMember struct {
+	Type string `json:"_type"`
+	ChatLabMember
+}
+
+type ndjsonMessage struct {
+	Type string `json:"_type"`
+	ChatLabMessage
+}
+
+// WriteNDJSON streams cl in the ChatLab JSONL format: a header line, then one
+// line per member and one line per message.
+func WriteNDJSON(w io.Writer, cl ChatLab) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ndjsonHeader{Type: ndjsonTypeHeader, ChatLab: cl.ChatLab, Meta: cl.Meta}); err != nil {
+		return err
+	}
+	for _, m := range cl.Members {
+		if err := enc.Encode(ndjsonMember{Type: ndjsonTypeMember, ChatLabMember: m}); err != nil {
+			return err
+		}
+	}
+	for _, msg := range cl.Messages {
+		if err := enc.Encode(ndjsonMessage{Type: ndjsonTypeMessage, ChatLabMessage: msg}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadNDJSON parses a ChatLab JSONL stream with error recovery: malformed lines
+// are skipped and reported, so a truncated file still yields its readable part.
+// Blank lines and lines starting with '#' are ignored. When the stream has no
+// member lines, members are collected from the messages.
+func ReadNDJSON(r io.Reader) (ChatLab, []error) {
+	cl := ChatLab{
+		Members:  make([]ChatLabMember, 0),
+		Messages: make([]ChatLabMessage, 0),
+	}
+	errs := make([]error, 0)
+	headerSeen := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var probe struct {
+			Type string `json:"_type"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", lineNo, err))
+			continue
+		}
+
+		switch probe.Type {
+		case ndjsonTypeHeader:
+			var h ndjsonHeader
+			if err := json.Unmarshal(line, &h); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", lineNo, err))
+				continue
+			}
+			if headerSeen {
+				errs = append(errs, fmt.Errorf("line %d: duplicate header", lineNo))
+				continue
+			}
+			if lineNo != 1 {
+				errs = append(errs, fmt.Errorf("line %d: header is not the first line", lineNo))
+			}
+			headerSeen = true
+			cl.ChatLab, cl.Meta = h.ChatLab, h.Meta
+		case ndjsonTypeMember:
+			var m ndjsonMember
+			if err := json.Unmarshal(line, &m); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", lineNo, err))
+				continue
+			}
+			cl.Members = append(cl.Members, m.ChatLabMember)
+		case ndjsonTypeMessage:
+			var m ndjsonMessage
+			if err := json.Unmarshal(line, &m); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", lineNo, err))
+				continue
+			}
+			cl.Messages = append(cl.Messages, m.ChatLabMessage)
+		default:
+			errs = append(errs, fmt.Errorf("line %d: unknown _type %q", lineNo, probe.Type))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("line %d: %w", lineNo+1, err))
+	}
+	if !headerSeen {
+		errs = append(errs, errors.New("missing header line"))
+	}
+
+	if len(cl.Members) == 0 {
+		cl.Members = collectMembers(cl.Messages, nil, cl.Meta.Type == "group")
+	}
+
+	return cl, errs
+}
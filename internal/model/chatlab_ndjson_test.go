@@ -0,0 +1,63 @@
+package model
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(1703001600, 0), Sender: "a", SenderName: "张三", Type: MessageTypeText, Content: "大家好！"},
+		{Time: time.Unix(1703001610, 0), Sender: "b", SenderName: "李四", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "img/1.jpg"}},
+	}
+	cl := ConvertToChatLab(messages, "1@chatroom", "技术交流群")
+
+	var buf bytes.Buffer
+	if err := WriteNDJSON(&buf, cl); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 5 {
+		t.Errorf("wrote %d lines, want 5", lines)
+	}
+
+	got, errs := ReadNDJSON(&buf)
+	if len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if !reflect.DeepEqual(got, cl) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got, cl)
+	}
+}
+
+func TestReadNDJSONRecovery(t *testing.T) {
+	input := `{"_type":"header","chatlab":{"version":"0.0.1","exportedAt":1703001600},"meta":{"name":"技术交流群","platform":"qq","type":"group"}}
+# 备注行
+{"_type":"message","sender":"123456","accountName":"张三","timestamp":1703001600,"type":0,"content":"大家好！"}
+{"_type":"message","sender":"789012","accountName":"李四","timestamp":17030
+{"_type":"reaction"}
+
+{"_type":"message","sender":"789012","accountName":"李四","timestamp":1703001620,"type":0,"content":"你好！"}
+{"_type":"message","sender":"123456","accountName":"张三","timestamp":1703001630,"ty`
+
+	cl, errs := ReadNDJSON(strings.NewReader(input))
+
+	if len(errs) != 3 {
+		t.Errorf("got %d errors, want 3: %v", len(errs), errs)
+	}
+	if cl.Meta.Name != "技术交流群" || cl.Meta.Platform != "qq" || cl.ChatLab.Version != "0.0.1" {
+		t.Errorf("header = %+v %+v", cl.ChatLab, cl.Meta)
+	}
+	if len(cl.Messages) != 2 || cl.Messages[1].Content != "你好！" {
+		t.Errorf("messages = %+v", cl.Messages)
+	}
+	if len(cl.Members) != 2 || cl.Members[0].PlatformID != "123456" || cl.Members[1].AccountName != "李四" || cl.Members[1].GroupNickname != "" {
+		t.Errorf("members = %+v", cl.Members)
+	}
+
+	if _, errs := ReadNDJSON(strings.NewReader(`{"_type":"message","sender":"a","timestamp":1,"type":0,"content":"x"}`)); len(errs) != 1 {
+		t.Errorf("missing header errors = %v", errs)
+	}
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Payment statuses recorded in ChatLabPayment.Status
+const (
+	PaymentStatusSend    = "send"
+	PaymentStatusReceive = "receive"
+	PaymentStatusRefund  = "refund"
+)
+
+// DefaultRefundWindow bounds how long after a transfer a refund without a
+// transfer ID may be paired with it; unaccepted WeChat transfers are returned
+// after 24 hours
+const DefaultRefundWindow = 48 * time.Hour
+
+// ChatLabPayment is the structured form of a transfer
+type ChatLabPayment struct {
+	Amount     string `json:"amount,omitempty"` // 十进制金额，如 "100.00"
+	Currency   string `json:"currency,omitempty"`
+	Memo       string `json:"memo,omitempty"`
+	Status     string `json:"status,omitempty"` // PaymentStatus*
+	TransferID string `json:"transferId,omitempty"`
+}
+
+// newChatLabPayment builds the payment of a transfer message from its Contents
+func newChatLabPayment(contents map[string]interface{}, platform string) *ChatLabPayment {
+	feeDesc := contentsString(contents, "feedesc")
+	if feeDesc == "" {
+		return nil
+	}
+	p := &ChatLabPayment{
+		Amount:     parseFeeAmount(feeDesc),
+		Memo:       contentsString(contents, "paymemo"),
+		TransferID: contentsString(contents, "transferid"),
+	}
+	p.Currency = parseCurrency(feeDesc, p.Memo)
+	if p.Currency == "" && platform == "wechat" {
+		p.Currency = "CNY"
+	}
+	if subType, ok := toInt64(contents["paysubtype"]); ok {
+		switch subType {
+		case 1, 7:
+			p.Status = PaymentStatusSend
+		case 3, 5:
+			p.Status = PaymentStatusReceive
+		case 4:
+			p.Status = PaymentStatusRefund
+		}
+	}
+	return p
+}
+
+// currencySymbols maps amount prefixes to ISO 4217 codes, longest first so
+// "HK$" wins over "$". A bare ¥ is read as CNY, as WeChat uses it.
+var currencySymbols = []struct{ symbol, code string }{
+	{"HK$", "HKD"}, {"US$", "USD"}, {"NT$", "TWD"}, {"MOP$", "MOP"},
+	{"￥", "CNY"}, {"¥", "CNY"}, {"$", "USD"}, {"€", "EUR"}, {"£", "GBP"},
+}
+
+// currencyCodes are the ISO 4217 codes recognised in fee descriptions and memos
+var currencyCodes = map[string]bool{
+	"CNY": true, "RMB": true, "USD": true, "EUR": true, "GBP": true, "JPY": true, "HKD": true,
+	"MOP": true, "TWD": true, "KRW": true, "SGD": true, "AUD": true, "CAD": true, "CHF": true,
+}
+
+// parseCurrency finds the currency of a transfer: an ISO code in the fee
+// description, then a symbol in it, then an ISO code in the memo. It returns
+// "" when none is found.
+func parseCurrency(feeDesc, memo string) string {
+	if code := findCurrencyCode(feeDesc); code != "" {
+		return code
+	}
+	for _, c := range currencySymbols {
+		if strings.Contains(feeDesc, c.symbol) {
+			return c.code
+		}
+	}
+	return findCurrencyCode(memo)
+}
+
+// findCurrencyCode returns the first known three-letter currency code in s
+func findCurrencyCode(s string) string {
+	for _, word := range strings.FieldsFunc(strings.ToUpper(s), func(r rune) bool { return r < 'A' || r > 'Z' }) {
+		if currencyCodes[word] {
+			if word == "RMB" {
+				return "CNY"
+			}
+			return word
+		}
+	}
+	return ""
+}
+
+// parseFeeAmount extracts the decimal amount from a fee description like "￥200.00"
+func parseFeeAmount(feeDesc string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' {
+			return r
+		}
+		return -1
+	}, feeDesc)
+}
+
+// pairRefunds sets RefundOf on refund messages to the index of the transfer
+// they return: the one with the same transfer ID, otherwise the latest unpaired
+// earlier transfer with the same amount and memo within window (timestamps in
+// seconds, or milliseconds when millis). Messages must be chronological.
+func pairRefunds(messages []ChatLabMessage, window time.Duration, millis bool) {
+	limit := int64(window / time.Second)
+	if millis {
+		limit = window.Milliseconds()
+	}
+	paired := make(map[int]bool)
+	for i, msg := range messages {
+		refund := msg.Payment
+		if refund == nil || refund.Status != PaymentStatusRefund {
+			continue
+		}
+		match := -1
+		for j := i - 1; j >= 0; j-- {
+			p := messages[j].Payment
+			if p == nil || p.Status != PaymentStatusSend || paired[j] {
+				continue
+			}
+			if refund.TransferID != "" && p.TransferID != "" {
+				if p.TransferID == refund.TransferID {
+					match = j
+					break
+				}
+				continue
+			}
+			if msg.Timestamp-messages[j].Timestamp > limit {
+				break
+			}
+			if p.Amount == refund.Amount && p.Memo == refund.Memo {
+				match = j
+				break
+			}
+		}
+		if match >= 0 {
+			paired[match] = true
+			index := match
+			messages[i].RefundOf = &index
+		}
+	}
+}
+
+// remapRefunds updates RefundOf indexes after messages were reversed
+func remapRefunds(messages []ChatLabMessage) {
+	for i := range messages {
+		if messages[i].RefundOf != nil {
+			index := len(messages) - 1 - *messages[i].RefundOf
+			messages[i].RefundOf = &index
+		}
+	}
+}
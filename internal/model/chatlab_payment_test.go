@@ -0,0 +1,89 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func transferMessage(t *testing.T, ts int64, sender string, paySubType int, fee, memo, transferID string) *Message {
+	t.Helper()
+	msg := &Message{Time: time.Unix(ts, 0), Sender: sender, Type: MessageTypeShare}
+	data := fmt.Sprintf(`<msg><appmsg><title>微信转账</title><type>2000</type><wcpayinfo><paysubtype>%d</paysubtype>`+
+		`<feedesc>%s</feedesc><pay_memo>%s</pay_memo><transferid>%s</transferid></wcpayinfo></appmsg></msg>`, paySubType, fee, memo, transferID)
+	if err := msg.ParseMediaInfo(data); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestConvertToChatLabPayment(t *testing.T) {
+	msg := transferMessage(t, 100, "a", 1, "￥200.00", "房租", "1000050001")
+
+	got := ConvertToChatLab([]*Message{msg}, "a", "A").Messages[0]
+
+	want := ChatLabPayment{Amount: "200.00", Currency: "CNY", Memo: "房租", Status: PaymentStatusSend, TransferID: "1000050001"}
+	if got.Type != ChatLabTypeTransfer || got.Payment == nil || *got.Payment != want {
+		t.Errorf("payment = %+v, want %+v", got.Payment, want)
+	}
+}
+
+func TestConvertToChatLabPairRefunds(t *testing.T) {
+	messages := []*Message{
+		transferMessage(t, 100, "me", 1, "￥50.00", "", ""),
+		transferMessage(t, 200, "me", 1, "￥88.00", "生日快乐", "tx1"),
+		{Time: time.Unix(300, 0), Sender: "a", Type: MessageTypeText, Content: "不用啦"},
+		transferMessage(t, 400, "a", 4, "￥88.00", "生日快乐", "tx1"),
+		transferMessage(t, 100+int64(DefaultRefundWindow/time.Second)+1, "a", 4, "￥50.00", "", ""),
+	}
+
+	opts := DefaultConvertOptions()
+	opts.PairRefunds = true
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if len(cl.Messages) != 5 {
+		t.Fatalf("got %d messages, want all kept", len(cl.Messages))
+	}
+	if r := cl.Messages[3].RefundOf; r == nil || *r != 1 {
+		t.Errorf("refund RefundOf = %v, want 1", r)
+	}
+	if r := cl.Messages[4].RefundOf; r != nil {
+		t.Errorf("refund outside window paired with %d", *r)
+	}
+	if cl.Messages[1].RefundOf != nil {
+		t.Error("transfer should not carry RefundOf")
+	}
+
+	opts.Reverse = true
+	cl = ConvertToChatLabWithOptions(messages, "a", "A", opts)
+	if r := cl.Messages[1].RefundOf; r == nil || cl.Messages[*r].Payment.TransferID != "tx1" || cl.Messages[*r].Payment.Status != PaymentStatusSend {
+		t.Errorf("reversed RefundOf = %v", r)
+	}
+}
+
+func TestConvertToChatLabPaymentCurrency(t *testing.T) {
+	tests := []struct {
+		fee, memo string
+		want      string
+	}{
+		{"$25.00", "", "USD"},
+		{"US$25.00", "", "USD"},
+		{"HK$100.00", "", "HKD"},
+		{"€9.90", "", "EUR"},
+		{"¥50.00", "", "CNY"},
+		{"25.00", "USD 货款", "USD"},
+		{"25.00", "bus fare", "CNY"},
+	}
+	for _, tt := range tests {
+		got := ConvertToChatLab([]*Message{transferMessage(t, 100, "a", 1, tt.fee, tt.memo, "")}, "a", "A").Messages[0].Payment
+		if got == nil || got.Currency != tt.want || got.Amount == "" {
+			t.Errorf("fee %q memo %q: payment = %+v, want currency %s", tt.fee, tt.memo, got, tt.want)
+		}
+	}
+
+	opts := DefaultConvertOptions()
+	opts.Platform = "qq"
+	if got := ConvertToChatLabWithOptions([]*Message{transferMessage(t, 100, "a", 1, "25.00", "", "")}, "a", "A", opts).Messages[0].Payment; got.Currency != "" {
+		t.Errorf("non-wechat unknown currency = %q, want empty", got.Currency)
+	}
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// RenderOptions controls the text renderers
+type RenderOptions struct {
+	// Location formats timestamps; nil means time.Local
+	Location *time.Location
+
+	// RelativeTime shows "+00:05:12" offsets from the earliest message instead
+	// of absolute times in the plain text and Markdown renderers
+	RelativeTime bool
+}
+
+// renderTimeLayout is the absolute timestamp layout used by all renderers
+const renderTimeLayout = "2006-01-02 15:04:05"
+
+// HumanSize formats a byte count with binary units, e.g. "1023 B", "1.0 KB", "1.2 MB"
+func HumanSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTP"[exp])
+}
+
+// RenderPlainText writes one "time name: content" line per message
+func RenderPlainText(w io.Writer, cl ChatLab, opts RenderOptions) error {
+	bw := bufio.NewWriter(w)
+	formatTime := opts.timeFormatter(cl)
+	for _, msg := range cl.Messages {
+		fmt.Fprintf(bw, "%s %s: %s\n", formatTime(msg.Timestamp), renderName(msg), renderContent(msg))
+	}
+	return bw.Flush()
+}
+
+// RenderMarkdown writes the conversation as a Markdown list under a title heading
+func RenderMarkdown(w io.Writer, cl ChatLab, opts RenderOptions) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n\n", cl.Meta.Name)
+	formatTime := opts.timeFormatter(cl)
+	for _, msg := range cl.Messages {
+		content := strings.ReplaceAll(renderContent(msg), "\n", "\n  ")
+		fmt.Fprintf(bw, "- **%s** `%s`: %s\n", renderName(msg), formatTime(msg.Timestamp), content)
+	}
+	return bw.Flush()
+}
+
+// RenderHTML writes the conversation as a minimal standalone HTML page
+func RenderHTML(w io.Writer, cl ChatLab, opts RenderOptions) error {
+	bw := bufio.NewWriter(w)
+	title := html.EscapeString(cl.Meta.Name)
+	fmt.Fprintf(bw, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title></head><body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	for _, msg := range cl.Messages {
+		content := strings.ReplaceAll(html.EscapeString(renderContent(msg)), "\n", "<br>")
+		fmt.Fprintf(bw, "<li><b>%s</b> <time>%s</time>: %s</li>\n",
+			html.EscapeString(renderName(msg)), opts.formatTime(cl.timeOf(msg.Timestamp)), content)
+	}
+	bw.WriteString("</ul>\n</body></html>\n")
+	return bw.Flush()
+}
+
+// debugDumpRunes caps the content shown per DebugDump line
+const debugDumpRunes = 80
+
+// DebugDump writes one compact line per message for logs and quick
+// inspection, e.g. "[3] 2024-01-02T09:00:00+08:00 wxid_a type=TEXT 你好".
+// Content is flattened and cut to 80 runes.
+// It is a developer aid, not an export format, so write errors are ignored.
+func (cl ChatLab) DebugDump(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	for i, msg := range cl.Messages {
+		content := truncateRunes(strings.Join(strings.Fields(msg.Content), " "), debugDumpRunes)
+		fmt.Fprintf(bw, "[%d] %s %s type=%s %s\n", i, cl.timeOf(msg.Timestamp).Format(time.RFC3339),
+			msg.Sender, ChatLabTypeName(msg.Type), content)
+	}
+	_ = bw.Flush()
+}
+
+// formatTime renders a message time for display
+func (o RenderOptions) formatTime(t time.Time) string {
+	loc := o.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return t.In(loc).Format(renderTimeLayout)
+}
+
+// timeFormatter returns a formatter for cl's timestamps: formatTime, or a
+// relative offset anchored at the earliest message when RelativeTime is set
+func (o RenderOptions) timeFormatter(cl ChatLab) func(int64) string {
+	if !o.RelativeTime || len(cl.Messages) == 0 {
+		return func(ts int64) string { return o.formatTime(cl.timeOf(ts)) }
+	}
+	start := cl.Messages[0].Timestamp
+	for _, msg := range cl.Messages[1:] {
+		if msg.Timestamp < start {
+			start = msg.Timestamp
+		}
+	}
+	return func(ts int64) string {
+		d := int64(cl.durationOf(ts-start) / time.Second)
+		return fmt.Sprintf("+%02d:%02d:%02d", d/3600, d/60%60, d%60)
+	}
+}
+
+// renderName is the display name of a message's sender
+func renderName(msg ChatLabMessage) string {
+	switch {
+	case msg.GroupNickname != "":
+		return msg.GroupNickname
+	case msg.AccountName != "":
+		return msg.AccountName
+	}
+	return msg.Sender
+}
+
+// renderContent is the display text of a message: files show their name and
+// size, other media their bracket label
+func renderContent(msg ChatLabMessage) string {
+	if msg.Type == ChatLabTypeFile {
+		label := chatLabTypeLabels[ChatLabTypeFile]
+		name := label
+		if msg.Content != "" && msg.Content != label {
+			name = label + " " + msg.Content
+		}
+		if msg.FileSize > 0 {
+			name += " (" + HumanSize(msg.FileSize) + ")"
+		}
+		return name
+	}
+	return previewContent(msg)
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{1<<20 - 1, "1024.0 KB"},
+		{1 << 20, "1.0 MB"},
+		{1288490189, "1.2 GB"},
+	}
+	for _, tt := range tests {
+		if got := HumanSize(tt.bytes); got != tt.want {
+			t.Errorf("HumanSize(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func renderTestChatLab() ChatLab {
+	loc := time.FixedZone("CST", 8*3600)
+	return ChatLab{
+		Meta: ChatLabMeta{Name: "技术<群>"},
+		Messages: []ChatLabMessage{
+			{Sender: "a", AccountName: "张三", Timestamp: time.Date(2024, 1, 2, 9, 0, 0, 0, loc).Unix(), Type: ChatLabTypeText, Content: "第一行\n第二行"},
+			{Sender: "b", AccountName: "李四", Timestamp: time.Date(2024, 1, 2, 9, 1, 0, 0, loc).Unix(), Type: ChatLabTypeFile, Content: "报告.pdf", FileSize: 1258291},
+			{Sender: "b", AccountName: "李四", Timestamp: time.Date(2024, 1, 2, 9, 2, 0, 0, loc).Unix(), Type: ChatLabTypeImage, Content: "/data/1.jpg"},
+		},
+	}
+}
+
+func TestRenderPlainText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderPlainText(&buf, renderTestChatLab(), RenderOptions{Location: time.FixedZone("CST", 8*3600)}); err != nil {
+		t.Fatal(err)
+	}
+	want := "2024-01-02 09:00:00 张三: 第一行\n第二行\n" +
+		"2024-01-02 09:01:00 李四: [文件] 报告.pdf (1.2 MB)\n" +
+		"2024-01-02 09:02:00 李四: [图片]\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRenderMarkdownAndHTML(t *testing.T) {
+	opts := RenderOptions{Location: time.FixedZone("CST", 8*3600)}
+
+	var md bytes.Buffer
+	if err := RenderMarkdown(&md, renderTestChatLab(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# 技术<群>\n", "- **张三** `2024-01-02 09:00:00`: 第一行\n  第二行\n", "[文件] 报告.pdf (1.2 MB)"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown missing %q:\n%s", want, md.String())
+		}
+	}
+
+	var page bytes.Buffer
+	if err := RenderHTML(&page, renderTestChatLab(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<h1>技术&lt;群&gt;</h1>", "第一行<br>第二行", "[文件] 报告.pdf (1.2 MB)"} {
+		if !strings.Contains(page.String(), want) {
+			t.Errorf("html missing %q:\n%s", want, page.String())
+		}
+	}
+}
+
+func TestRenderRelativeTime(t *testing.T) {
+	cl := renderTestChatLab()
+	cl.Messages[2].Timestamp = cl.Messages[0].Timestamp + 2*3600 + 5*60 + 12
+	opts := RenderOptions{RelativeTime: true}
+
+	var buf bytes.Buffer
+	if err := RenderPlainText(&buf, cl, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := "+00:00:00 张三: 第一行\n第二行\n" +
+		"+00:01:00 李四: [文件] 报告.pdf (1.2 MB)\n" +
+		"+02:05:12 李四: [图片]\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	var md bytes.Buffer
+	if err := RenderMarkdown(&md, cl, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md.String(), "- **李四** `+02:05:12`: [图片]\n") {
+		t.Errorf("markdown missing relative time:\n%s", md.String())
+	}
+}
+
+func TestChatLabDebugDump(t *testing.T) {
+	cl := renderTestChatLab()
+	cl.Messages[0].Content = strings.Repeat("长", 100)
+
+	var buf bytes.Buffer
+	cl.DebugDump(&buf)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines = %q", lines)
+	}
+	ts := time.Unix(cl.Messages[1].Timestamp, 0).Format(time.RFC3339)
+	if want := "[1] " + ts + " b type=FILE 报告.pdf"; lines[1] != want {
+		t.Errorf("lines[1] = %q, want %q", lines[1], want)
+	}
+	if !strings.HasSuffix(lines[0], " type=TEXT "+strings.Repeat("长", 79)+"…") {
+		t.Errorf("lines[0] = %q, want content cut to 80 runes", lines[0])
+	}
+}
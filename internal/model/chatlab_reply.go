@@ -0,0 +1,157 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ReplyRecalledPlaceholder is the ReplyTo content for quotes of recalled messages
+const ReplyRecalledPlaceholder = "[引用的消息已撤回]"
+
+// ChatLabReplyTo is the message quoted by a reply
+type ChatLabReplyTo struct {
+	Sender      string `json:"sender"`
+	AccountName string `json:"accountName,omitempty"`
+	Timestamp   int64  `json:"timestamp,omitempty"`
+	Type        int    `json:"type"`
+	Content     string `json:"content"`
+}
+
+// newChatLabReplyTo builds the quote of a reply from Contents["refer"].
+// The quoted message is mapped like a top-level one so media quotes read as
+// placeholders; an empty or recalled quote reads as ReplyRecalledPlaceholder.
+func newChatLabReplyTo(msg *Message, opts ConvertOptions) *ChatLabReplyTo {
+	if msg.Contents == nil {
+		return nil
+	}
+	refer, ok := msg.Contents["refer"].(*Message)
+	if !ok || refer == nil {
+		return nil
+	}
+
+	quoted := ChatLabMessage{Content: refer.Content}
+	mapMessage(refer, &quoted, opts)
+
+	reply := &ChatLabReplyTo{
+		Sender:      refer.Sender,
+		AccountName: refer.SenderName,
+		Type:        quoted.Type,
+		Content:     quoted.Content,
+	}
+	if !refer.Time.IsZero() && refer.Time.Unix() > 0 {
+		reply.Timestamp = opts.timestamp(refer.Time)
+	}
+	if isRecalledQuote(quoted) {
+		reply.Content = ReplyRecalledPlaceholder
+	}
+	return reply
+}
+
+// DefaultInlineQuoteRunes caps the quoted text of ConvertOptions.InlineQuotes
+const DefaultInlineQuoteRunes = 50
+
+// inlineQuote prefixes a reply's Content with "> sender: quote\n", the quote
+// on one line and cut to InlineQuoteRunes
+func (o ConvertOptions) inlineQuote(msg *ChatLabMessage) {
+	reply := msg.ReplyTo
+	if reply == nil {
+		return
+	}
+	maxRunes := o.InlineQuoteRunes
+	if maxRunes <= 0 {
+		maxRunes = DefaultInlineQuoteRunes
+	}
+	name := reply.AccountName
+	if name == "" {
+		name = reply.Sender
+	}
+	quote := strings.Join(strings.Fields(reply.Content), " ")
+	msg.Content = "> " + name + ": " + truncateRunes(quote, maxRunes) + "\n" + msg.Content
+}
+
+// isRecalledQuote reports whether the quoted message is gone: its content is
+// blank or it is itself a recall notice
+func isRecalledQuote(quoted ChatLabMessage) bool {
+	if strings.TrimSpace(quoted.Content) == "" || quoted.Type == ChatLabTypeRecall {
+		return true
+	}
+	notice, ok := parseSystemMessage(quoted.Content)
+	return ok && (notice.Kind == SystemKindRecall || notice.Kind == SystemKindAdminRevoke)
+}
+
+// replyIndex finds the in-batch messages that replies quote, keyed by sender
+// and timestamp
+type replyIndex map[string][]int
+
+func replyKey(sender string, ts int64) string {
+	return sender + "\x00" + strconv.FormatInt(ts, 10)
+}
+
+// newReplyIndex indexes messages, which must be in chronological order
+func newReplyIndex(messages []ChatLabMessage) replyIndex {
+	idx := make(replyIndex)
+	for i, msg := range messages {
+		k := replyKey(msg.Sender, msg.Timestamp)
+		idx[k] = append(idx[k], i)
+	}
+	return idx
+}
+
+// target returns the index of the message quoted by messages[i], or -1 when it
+// is not in the batch. Among same-second candidates before i, a content match
+// wins, otherwise the latest one.
+func (idx replyIndex) target(messages []ChatLabMessage, i int) int {
+	reply := messages[i].ReplyTo
+	if reply == nil || reply.Timestamp == 0 {
+		return -1
+	}
+	found := -1
+	for _, j := range idx[replyKey(reply.Sender, reply.Timestamp)] {
+		if j >= i {
+			break
+		}
+		if messages[j].Content == reply.Content {
+			return j
+		}
+		found = j
+	}
+	return found
+}
+
+// resolveReplies sets the quote of replies whose target is in the batch to the
+// target's own Content. Only one level is pulled: a quoted reply contributes
+// its text, never its own quote, so chains do not nest. Media and other
+// non-text targets are quoted by their bracket label, never their path or
+// data: URI. Messages must be in chronological order.
+func resolveReplies(messages []ChatLabMessage) {
+	idx := newReplyIndex(messages)
+	for i := range messages {
+		if messages[i].ReplyTo == nil {
+			continue
+		}
+		if j := idx.target(messages, i); j >= 0 {
+			switch messages[j].Type {
+			case ChatLabTypeText, ChatLabTypeReply:
+				messages[i].ReplyTo.Content = messages[j].Content
+			default:
+				messages[i].ReplyTo.Content = chatLabTypeLabel(messages[j].Type)
+			}
+		}
+	}
+}
+
+// assignReplyDepth sets ReplyDepth on chronologically ordered messages: 0 for
+// non-replies, otherwise one more than the quoted message when it is in the
+// batch, or 1 when it is not
+func assignReplyDepth(messages []ChatLabMessage) {
+	idx := newReplyIndex(messages)
+	for i := range messages {
+		if messages[i].ReplyTo == nil {
+			continue
+		}
+		messages[i].ReplyDepth = 1
+		if j := idx.target(messages, i); j >= 0 {
+			messages[i].ReplyDepth = messages[j].ReplyDepth + 1
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func quoteMessage(content string, refer *Message) *Message {
+	return &Message{
+		Time:     time.Unix(200, 0),
+		Sender:   "b",
+		Type:     MessageTypeShare,
+		SubType:  MessageSubTypeQuote,
+		Content:  content,
+		Contents: map[string]interface{}{"refer": refer},
+	}
+}
+
+func TestConvertToChatLabReplyTo(t *testing.T) {
+	messages := []*Message{
+		quoteMessage("好的", &Message{Time: time.Unix(100, 0), Sender: "a", SenderName: "A", Type: MessageTypeText, Content: "明天见"}),
+		quoteMessage("这张不错", &Message{Sender: "a", Type: MessageTypeImage}),
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	want := ChatLabReplyTo{Sender: "a", AccountName: "A", Timestamp: 100, Type: ChatLabTypeText, Content: "明天见"}
+	if got := cl.Messages[0].ReplyTo; got == nil || *got != want {
+		t.Errorf("replyTo = %+v, want %+v", got, want)
+	}
+	if cl.Messages[0].Content != "好的" {
+		t.Errorf("content = %q, want reply text", cl.Messages[0].Content)
+	}
+	if got := cl.Messages[1].ReplyTo; got == nil || got.Type != ChatLabTypeImage || got.Content != "[图片]" {
+		t.Errorf("media quote = %+v, want image placeholder", got)
+	}
+}
+
+func TestConvertToChatLabReplyToRecalled(t *testing.T) {
+	messages := []*Message{
+		quoteMessage("你说什么？", &Message{Sender: "a", Type: MessageTypeText, Content: ""}),
+		quoteMessage("？", &Message{Sender: "a", Type: MessageTypeSystem, Content: `"A" 撤回了一条消息`}),
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	for i, want := range []string{"你说什么？", "？"} {
+		msg := cl.Messages[i]
+		if msg.ReplyTo == nil || msg.ReplyTo.Content != ReplyRecalledPlaceholder {
+			t.Errorf("messages[%d].ReplyTo = %+v, want recalled placeholder", i, msg.ReplyTo)
+		}
+		if msg.Content != want {
+			t.Errorf("messages[%d].Content = %q, want %q", i, msg.Content, want)
+		}
+	}
+}
+
+func TestConvertToChatLabInlineQuotes(t *testing.T) {
+	messages := []*Message{
+		quoteMessage("好的", &Message{Sender: "a", SenderName: "A", Type: MessageTypeText, Content: "明天\n见"}),
+		quoteMessage("同意", &Message{Sender: "wxid_c", Type: MessageTypeText, Content: "一二三四五六七八九十"}),
+	}
+	opts := DefaultConvertOptions()
+	opts.InlineQuotes = true
+	opts.InlineQuoteRunes = 6
+
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	for i, want := range []string{"> A: 明天 见\n好的", "> wxid_c: 一二三四五…\n同意"} {
+		if got := cl.Messages[i].Content; got != want {
+			t.Errorf("messages[%d].Content = %q, want %q", i, got, want)
+		}
+	}
+	if cl.Messages[0].ReplyTo == nil || cl.Messages[0].ReplyTo.Content != "明天\n见" {
+		t.Errorf("ReplyTo = %+v, want it kept", cl.Messages[0].ReplyTo)
+	}
+	if got := ConvertToChatLab(messages, "1@chatroom", "群").Messages[0].Content; got != "好的" {
+		t.Errorf("default content = %q", got)
+	}
+}
+
+func TestConvertToChatLabInlineQuotesMediaTarget(t *testing.T) {
+	image := &Message{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "img/1.jpg"}}
+	reply := quoteMessage("nice", &Message{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeImage})
+	opts := DefaultConvertOptions()
+	opts.InlineQuotes = true
+
+	cl := ConvertToChatLabWithOptions([]*Message{image, reply}, "1@chatroom", "群", opts)
+
+	if q := cl.Messages[1].ReplyTo; q == nil || q.Content != "[图片]" {
+		t.Errorf("ReplyTo = %+v, want the image placeholder", q)
+	}
+	if got := cl.Messages[1].Content; got != "> a: [图片]\nnice" {
+		t.Errorf("inline content = %q", got)
+	}
+}
+
+func TestConvertToChatLabReplyToReply(t *testing.T) {
+	question := &Message{Time: time.Unix(100, 0), Sender: "a", SenderName: "A", Type: MessageTypeText, Content: "几点集合？"}
+	answer := quoteMessage("八点", question)
+	answer.Time, answer.SenderName = time.Unix(150, 0), "B"
+	// The client quotes a reply by its raw appmsg title, quote included
+	followUp := quoteMessage("太早了", &Message{Time: time.Unix(150, 0), Sender: "b", SenderName: "B", Type: MessageTypeText, Content: "八点\n「A：几点集合？」"})
+	messages := []*Message{question, answer, followUp}
+
+	opts := DefaultConvertOptions()
+	opts.InlineQuotes = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	reply := cl.Messages[2].ReplyTo
+	if reply == nil || reply.Sender != "b" || reply.Content != "八点" {
+		t.Fatalf("ReplyTo = %+v, want the quoted reply's own text", reply)
+	}
+	if got := cl.Messages[2].Content; got != "> B: 八点\n太早了" {
+		t.Errorf("inline content = %q", got)
+	}
+	if got := cl.Messages[1].Content; got != "> A: 几点集合？\n八点" {
+		t.Errorf("first reply content = %q", got)
+	}
+}
+
+func TestConvertToChatLabReplyDepth(t *testing.T) {
+	root := &Message{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeText, Content: "周五聚餐？"}
+	first := quoteMessage("可以", &Message{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeText, Content: "周五聚餐？"})
+	first.Time = time.Unix(200, 0)
+	second := quoteMessage("几点？", &Message{Time: time.Unix(200, 0), Sender: "b", Type: MessageTypeText, Content: "可以"})
+	second.Sender, second.Time = "c", time.Unix(300, 0)
+	third := quoteMessage("七点", &Message{Time: time.Unix(300, 0), Sender: "c", Type: MessageTypeText, Content: "几点？"})
+	third.Sender, third.Time = "a", time.Unix(400, 0)
+	outside := quoteMessage("上周那个？", &Message{Time: time.Unix(50, 0), Sender: "z", Type: MessageTypeText, Content: "不在本批次"})
+	outside.Time = time.Unix(500, 0)
+	messages := []*Message{root, first, second, third, outside}
+
+	opts := DefaultConvertOptions()
+	opts.IncludeReplyDepth = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	for i, want := range []int{0, 1, 2, 3, 1} {
+		if got := cl.Messages[i].ReplyDepth; got != want {
+			t.Errorf("messages[%d].ReplyDepth = %d, want %d", i, got, want)
+		}
+	}
+
+	if got := ConvertToChatLab(messages, "1@chatroom", "群").Messages[3].ReplyDepth; got != 0 {
+		t.Errorf("ReplyDepth = %d without IncludeReplyDepth", got)
+	}
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// sampleStart is the first timestamp of generated sample conversations (2023-12-20 00:00:00 +08:00)
+const sampleStart = 1703001600
+
+var (
+	sampleNames = []string{"张三", "李四", "王五", "赵六", "孙七", "周八", "吴九", "郑十"}
+	sampleTexts = []string{"大家好", "收到", "明天几点开会？", "好的，没问题", "哈哈哈", "这个方案我再看看", "晚上一起吃饭吗", "👍"}
+)
+
+// GenerateSampleChatLab builds a reproducible conversation for tests: the same
+// seed and sizes always produce identical output. It mixes text, media, link,
+// reply and system messages with increasing timestamps, and every message
+// sender is a listed member. More than two members make it a group.
+func GenerateSampleChatLab(seed int64, messageCount, memberCount int) ChatLab {
+	if memberCount < 1 {
+		memberCount = 1
+	}
+	r := rand.New(rand.NewSource(seed))
+
+	cl := ChatLab{
+		ChatLab: ChatLabHeader{Version: "0.0.1", ExportedAt: sampleStart, Generator: "Chatlog"},
+		Meta:    ChatLabMeta{Name: "示例对话", Platform: "wechat", Type: "private"},
+		Members: make([]ChatLabMember, 0, memberCount),
+	}
+	isGroup := memberCount > 2
+	if isGroup {
+		cl.Meta.Type = "group"
+		cl.Meta.GroupID = fmt.Sprintf("%d@chatroom", seed)
+		cl.Meta.Name = "示例群聊"
+	}
+	for i := 0; i < memberCount; i++ {
+		m := ChatLabMember{
+			PlatformID:  fmt.Sprintf("wxid_sample%03d", i),
+			AccountName: sampleNames[i%len(sampleNames)],
+			IsSelf:      i == 0,
+		}
+		if i >= len(sampleNames) {
+			m.AccountName = fmt.Sprintf("%s%d", m.AccountName, i/len(sampleNames))
+		}
+		if isGroup {
+			m.GroupNickname = m.AccountName
+		}
+		cl.Members = append(cl.Members, m)
+	}
+
+	cl.Messages = make([]ChatLabMessage, 0, messageCount)
+	ts := int64(sampleStart)
+	for i := 0; i < messageCount; i++ {
+		ts += int64(1 + r.Intn(600))
+		m := cl.Members[r.Intn(len(cl.Members))]
+		msg := ChatLabMessage{
+			Sender:        m.PlatformID,
+			AccountName:   m.AccountName,
+			GroupNickname: m.GroupNickname,
+			Timestamp:     ts,
+			Type:          ChatLabTypeText,
+			Content:       sampleTexts[r.Intn(len(sampleTexts))],
+		}
+		switch n := r.Intn(20); {
+		case n < 2:
+			msg.Type, msg.Content = ChatLabTypeImage, chatLabTypeLabels[ChatLabTypeImage]
+		case n < 3:
+			msg.Type, msg.Content = ChatLabTypeVoice, chatLabTypeLabels[ChatLabTypeVoice]
+		case n < 4:
+			msg.Type, msg.Content = ChatLabTypeEmoji, chatLabTypeLabels[ChatLabTypeEmoji]
+		case n < 5:
+			url := fmt.Sprintf("https://example.com/article/%d", r.Intn(1000))
+			msg.Type, msg.Content = ChatLabTypeLink, url
+			msg.Share = &ChatLabShare{Title: "示例文章", URL: url}
+		case n < 6 && i > 0:
+			quoted := cl.Messages[r.Intn(i)]
+			msg.Type = ChatLabTypeReply
+			msg.ReplyTo = &ChatLabReplyTo{Sender: quoted.Sender, AccountName: quoted.AccountName, Timestamp: quoted.Timestamp, Type: quoted.Type, Content: quoted.Content}
+		case n < 7 && isGroup:
+			msg.Type, msg.Content = ChatLabTypeSystem, fmt.Sprintf(`"%s"修改群名为"示例群聊"`, m.AccountName)
+		}
+		cl.Messages = append(cl.Messages, msg)
+	}
+	return cl
+}
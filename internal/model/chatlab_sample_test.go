@@ -0,0 +1,43 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGenerateSampleChatLab(t *testing.T) {
+	a := GenerateSampleChatLab(42, 200, 5)
+	b := GenerateSampleChatLab(42, 200, 5)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("same seed produced different output")
+	}
+	if reflect.DeepEqual(a.Messages, GenerateSampleChatLab(7, 200, 5).Messages) {
+		t.Error("different seeds produced identical messages")
+	}
+
+	if len(a.Messages) != 200 || len(a.Members) != 5 || a.Meta.Type != "group" {
+		t.Fatalf("got %d messages, %d members, type %q", len(a.Messages), len(a.Members), a.Meta.Type)
+	}
+	if issues := a.Validate(ValidateOptions{CheckMonotonic: true}); len(issues) != 0 {
+		t.Errorf("issues = %+v", issues)
+	}
+
+	members := make(map[string]bool)
+	for _, m := range a.Members {
+		members[m.PlatformID] = true
+	}
+	types := make(map[int]bool)
+	for i, msg := range a.Messages {
+		if !members[msg.Sender] {
+			t.Errorf("messages[%d].Sender %q is not a member", i, msg.Sender)
+		}
+		types[msg.Type] = true
+	}
+	if len(types) < 4 {
+		t.Errorf("only %d message types generated", len(types))
+	}
+
+	if p := GenerateSampleChatLab(1, 10, 2); p.Meta.Type != "private" || len(p.Messages) != 10 {
+		t.Errorf("private sample = %+v", p.Meta)
+	}
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// EstimateJSONSize approximates the byte size of json.Marshal(cl) without
+// marshaling, by summing field lengths with their JSON key and escaping
+// overhead. Callers can use it to choose between marshaling and streaming.
+func (cl ChatLab) EstimateJSONSize() int {
+	var e sizeEstimator
+	e.object(func() {
+		var header sizeEstimator
+		header.object(func() {
+			header.field("version", jsonStringLen(cl.ChatLab.Version))
+			header.field("exportedAt", len(strconv.FormatInt(cl.ChatLab.ExportedAt, 10)))
+			header.str("exportedAtISO", cl.ChatLab.ExportedAtISO)
+			header.str("timestampUnit", cl.ChatLab.TimestampUnit)
+			header.str("generator", cl.ChatLab.Generator)
+			header.str("description", cl.ChatLab.Description)
+			header.str("checksum", cl.ChatLab.Checksum)
+		})
+		e.field("chatlab", header.n-1)
+		var meta sizeEstimator
+		meta.object(func() {
+			meta.field("name", jsonStringLen(cl.Meta.Name))
+			meta.field("platform", jsonStringLen(cl.Meta.Platform))
+			meta.field("type", jsonStringLen(cl.Meta.Type))
+			meta.str("groupId", cl.Meta.GroupID)
+			meta.str("groupAvatar", cl.Meta.GroupAvatar)
+			meta.str("conversationId", cl.Meta.ConversationID)
+		})
+		e.field("meta", meta.n-1)
+		if cl.Summary != nil {
+			e.field("summary", 120+60*len(cl.Summary.TopSenders))
+		}
+		e.field("members", 2)
+		for _, m := range cl.Members {
+			e.n += e.member(m) + 1
+		}
+		e.field("messages", 2)
+		for _, msg := range cl.Messages {
+			e.n += e.message(msg) + 1
+		}
+	})
+	return e.n
+}
+
+// sizeEstimator accumulates an estimated JSON byte count
+type sizeEstimator struct {
+	n int
+}
+
+// object counts the braces around fields written by fn
+func (e *sizeEstimator) object(fn func()) {
+	e.n += 2
+	fn()
+}
+
+// field counts `"key":` plus a value of valueLen bytes and a separator
+func (e *sizeEstimator) field(key string, valueLen int) {
+	e.n += len(key) + 4 + valueLen
+}
+
+// str counts an omitempty string field
+func (e *sizeEstimator) str(key, value string) {
+	if value != "" {
+		e.field(key, jsonStringLen(value))
+	}
+}
+
+// strs counts an omitempty string array field
+func (e *sizeEstimator) strs(key string, values []string) {
+	if len(values) > 0 {
+		e.field(key, jsonStringsLen(values))
+	}
+}
+
+func (e *sizeEstimator) member(m ChatLabMember) int {
+	var sub sizeEstimator
+	sub.object(func() {
+		sub.field("platformId", jsonStringLen(m.PlatformID))
+		sub.field("accountName", jsonStringLen(m.AccountName))
+		sub.str("groupNickname", m.GroupNickname)
+		sub.strs("aliases", m.Aliases)
+		sub.str("avatar", m.Avatar)
+		if m.IsSelf {
+			sub.field("isSelf", 4)
+		}
+		if m.IsOfficialAccount {
+			sub.field("isOfficialAccount", 4)
+		}
+	})
+	return sub.n
+}
+
+func (e *sizeEstimator) message(msg ChatLabMessage) int {
+	var sub sizeEstimator
+	sub.object(func() {
+		sub.field("sender", jsonStringLen(msg.Sender))
+		sub.field("accountName", jsonStringLen(msg.AccountName))
+		sub.str("groupNickname", msg.GroupNickname)
+		sub.field("timestamp", len(strconv.FormatInt(msg.Timestamp, 10)))
+		sub.str("timestampISO", msg.TimestampISO)
+		sub.field("type", len(strconv.Itoa(msg.Type)))
+		sub.field("content", jsonStringLen(msg.Content))
+		for _, f := range [...]struct{ key, value string }{
+			{"patFrom", msg.PatFrom}, {"patTo", msg.PatTo}, {"patSuffix", msg.PatSuffix}, {"thumb", msg.Thumb}, {"md5", msg.MD5},
+			{"shareKind", msg.ShareKind}, {"systemKind", msg.SystemKind}, {"revokedBy", msg.RevokedBy}, {"inviter", msg.Inviter},
+			{"cdnUrl", msg.CDNUrl}, {"source", msg.Source}, {"address", msg.Address}, {"lat", msg.Latitude}, {"lng", msg.Longitude}, {"caption", msg.Caption}, {"stickerPack", msg.StickerPack}, {"stickerAuthor", msg.StickerAuthor}, {"voiceFormat", msg.VoiceFormat},
+			{"_source", msg.SourceXML}, {"_original", msg.OriginalContent}, {"direction", msg.Direction}, {"lang", msg.Lang},
+		} {
+			sub.str(f.key, f.value)
+		}
+		for _, f := range [...]struct {
+			key   string
+			value int64
+		}{
+			{"seq", int64(msg.Seq)}, {"fileSize", msg.FileSize}, {"replyDepth", int64(msg.ReplyDepth)},
+		} {
+			if f.value != 0 {
+				sub.field(f.key, len(strconv.FormatInt(f.value, 10)))
+			}
+		}
+		for _, f := range [...]struct {
+			key   string
+			value bool
+		}{
+			{"forwarded", msg.Forwarded}, {"animated", msg.Animated}, {"encrypted", msg.Encrypted}, {"bot", msg.Bot}, {"mentionsAll", msg.MentionsAll},
+		} {
+			if f.value {
+				sub.field(f.key, 4)
+			}
+		}
+		sub.strs("joined", msg.Joined)
+		sub.strs("mentions", msg.Mentions)
+		if s := msg.Share; s != nil {
+			sub.field("share", 60+jsonStringLen(s.Title)+jsonStringLen(s.Desc)+jsonStringLen(s.URL)+
+				jsonStringLen(s.Author)+jsonStringLen(s.Thumb)+len(strconv.FormatInt(s.PublishedAt, 10)))
+		}
+		if p := msg.Payment; p != nil {
+			sub.field("payment", 60+jsonStringLen(p.Amount)+jsonStringLen(p.Currency)+jsonStringLen(p.Memo)+
+				jsonStringLen(p.Status)+jsonStringLen(p.TransferID))
+		}
+		if msg.Played != nil {
+			sub.field("played", len(strconv.FormatBool(*msg.Played)))
+		}
+		if msg.RefundOf != nil {
+			sub.field("refundOf", len(strconv.Itoa(*msg.RefundOf)))
+		}
+		if c := msg.Contact; c != nil {
+			sub.field("contact", 60+jsonStringLen(c.Username)+jsonStringLen(c.Nickname)+jsonStringLen(c.Alias)+
+				jsonStringLen(c.AvatarURL)+jsonStringLen(c.Avatar))
+		}
+		if r := msg.ReplyTo; r != nil {
+			sub.field("replyTo", 60+jsonStringLen(r.Sender)+jsonStringLen(r.AccountName)+jsonStringLen(r.Content))
+		}
+		if len(msg.Reactions) > 0 {
+			n := 2
+			for _, r := range msg.Reactions {
+				n += 20 + jsonStringLen(r.Emoji) + jsonStringsLen(r.By)
+			}
+			sub.field("reactions", n)
+		}
+		if len(msg.Edits) > 0 {
+			n := 2
+			for _, ed := range msg.Edits {
+				n += 36 + jsonStringLen(ed.Content)
+			}
+			sub.field("edits", n)
+		}
+		if len(msg.Children) > 0 {
+			n := 2
+			for _, child := range msg.Children {
+				n += e.message(child) + 1
+			}
+			sub.field("children", n)
+		}
+	})
+	return sub.n
+}
+
+// jsonStringLen is the encoded length of s as a JSON string, including quotes
+// and encoding/json's HTML-safe escapes
+func jsonStringLen(s string) int {
+	n := 2
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\' || c == '\n' || c == '\r' || c == '\t':
+				n += 2
+			case c < 0x20 || c == '<' || c == '>' || c == '&':
+				n += 6
+			default:
+				n++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == '\u2028' || r == '\u2029' || r == utf8.RuneError && size == 1 {
+			n += 6
+		} else {
+			n += size
+		}
+		i += size
+	}
+	return n
+}
+
+// jsonStringsLen is the encoded length of a JSON string array
+func jsonStringsLen(values []string) int {
+	n := 2
+	for _, v := range values {
+		n += jsonStringLen(v) + 1
+	}
+	return n
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestChatLabEstimateJSONSize(t *testing.T) {
+	cl := GenerateSampleChatLab(3, 2000, 6)
+	cl.Messages[0].Content = "含有 \"引号\"、<标签> 和\n换行"
+	cl.Messages[1].Payment = &ChatLabPayment{Amount: "88.00", Currency: "CNY", Status: PaymentStatusSend}
+	cl.Messages[2].Reactions = []ChatLabReaction{{Emoji: "👍", By: []string{"wxid_sample001"}}}
+	cl.Messages[3].Children = []ChatLabMessage{{Sender: "x", AccountName: "X", Timestamp: 1, Content: "转发内容", Forwarded: true}}
+
+	for _, c := range []ChatLab{cl, {}, GenerateSampleChatLab(9, 10, 2)} {
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, estimate := len(data), c.EstimateJSONSize()
+		if diff := float64(estimate-actual) / float64(actual); diff > 0.1 || diff < -0.1 {
+			t.Errorf("estimate %d vs actual %d (%.1f%%)", estimate, actual, diff*100)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// System message kinds recorded in ChatLabMessage.SystemKind
+const (
+	SystemKindRecall      = "recall"
+	SystemKindAdminRevoke = "admin_revoke"
+	SystemKindDateDivider = "date_divider"
+	SystemKindJoinInvite  = "join_invite"
+	SystemKindJoinQR      = "join_qr"
+	SystemKindJoinAdmin   = "join_admin"
+	SystemKindNameChange  = "name_change"
+)
+
+var (
+	// "管理员" 撤回了 "张三" 的一条消息
+	adminRevokeRegexp = regexp.MustCompile(`^\s*"?([^"]+?)"?\s*撤回了\s*"([^"]+)"\s*的一条消息`)
+	// "张三" 撤回了一条消息 / 你撤回了一条消息
+	recallRegexp = regexp.MustCompile(`^\s*"?([^"]+?)"?\s*撤回了一条消息`)
+	// 群管理员"张三"已将"李四"添加到群聊 / 群主"张三"将"李四"拉进了群聊
+	joinAdminRegexp = regexp.MustCompile(`^\s*(?:群主|群管理员)\s*"?([^"]+?)"?\s*(?:已)?将\s*"([^"]+)"\s*(?:添加|拉)(?:到|进)了?群聊`)
+	// "李四"通过扫描"张三"分享的二维码加入群聊 / 你通过扫描二维码加入群聊
+	joinQRRegexp = regexp.MustCompile(`^\s*"?([^"]+?)"?\s*通过扫描\s*(?:"?([^"]+?)"?\s*分享的)?二维码加入了?群聊`)
+	// "张三"邀请"李四、王五"加入了群聊 / 你邀请"李四"加入了群聊
+	joinInviteRegexp = regexp.MustCompile(`^\s*"?([^"]+?)"?\s*邀请\s*"?([^"]+?)"?\s*加入了群聊`)
+	// "张三"修改群名为“周末爬山群” / 你修改群名为"新群名"
+	nameChangeRegexp = regexp.MustCompile(`^\s*"?([^"]+?)"?\s*修改群名为\s*[“"](.*)[”"]\s*$`)
+)
+
+// systemNotice is the structured form of a recognised system message
+type systemNotice struct {
+	Kind   string // SystemKind*
+	Actor  string // 操作人
+	Target string // 被操作人，多人时以 "、" 分隔
+}
+
+// parseSystemMessage classifies the plain-text content of a system message.
+// For joins, Actor is the inviter, QR code sharer (empty when not named) or
+// admin and Target the joiners; for renames, Target is the new group name.
+func parseSystemMessage(content string) (systemNotice, bool) {
+	if m := adminRevokeRegexp.FindStringSubmatch(content); m != nil {
+		return systemNotice{Kind: SystemKindAdminRevoke, Actor: m[1], Target: m[2]}, true
+	}
+	if m := recallRegexp.FindStringSubmatch(content); m != nil {
+		return systemNotice{Kind: SystemKindRecall, Actor: m[1]}, true
+	}
+	if m := joinAdminRegexp.FindStringSubmatch(content); m != nil {
+		return systemNotice{Kind: SystemKindJoinAdmin, Actor: m[1], Target: m[2]}, true
+	}
+	if m := joinQRRegexp.FindStringSubmatch(content); m != nil {
+		return systemNotice{Kind: SystemKindJoinQR, Actor: m[2], Target: m[1]}, true
+	}
+	if m := joinInviteRegexp.FindStringSubmatch(content); m != nil {
+		return systemNotice{Kind: SystemKindJoinInvite, Actor: m[1], Target: m[2]}, true
+	}
+	if m := nameChangeRegexp.FindStringSubmatch(content); m != nil {
+		return systemNotice{Kind: SystemKindNameChange, Actor: m[1], Target: m[2]}, true
+	}
+	return systemNotice{}, false
+}
+
+// patRegexp matches pat notices such as `"张三" 拍了拍 "李四"` or `我拍了拍"李四"的肩膀`.
+// The target may be quoted (followed by an optional suffix) or bare.
+var patRegexp = regexp.MustCompile(`^\s*"?([^"]+?)"?\s*拍了拍\s*(?:"([^"]+)"(.*)|(.+))$`)
+
+// parsePat extracts the actor, target and custom suffix (e.g. "的狗头") of a
+// pat (拍一拍) notice. It is shared by the appmsg pat branch and
+// system-delivered pat notices. A bare target is only split from its suffix
+// when it is the owner ("拍了拍我的狗头"); other bare names are kept whole.
+func parsePat(content string) (from, to, suffix string, ok bool) {
+	m := patRegexp.FindStringSubmatch(content)
+	if m == nil {
+		return "", "", "", false
+	}
+	from = cleanPatName(m[1])
+	if m[2] != "" {
+		to = cleanPatName(m[2])
+		suffix = strings.TrimSpace(m[3])
+	} else {
+		to = cleanPatName(m[4])
+		if rest := strings.TrimPrefix(to, "我"); rest != to && rest != "" {
+			to, suffix = "我", strings.TrimSpace(rest)
+		}
+	}
+	if from == "" || to == "" {
+		return "", "", "", false
+	}
+	return from, to, suffix, true
+}
+
+// cleanPatName trims whitespace and the ${wxid} template wrapper used by pat records.
+func cleanPatName(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "${") && strings.HasSuffix(name, "}") {
+		name = name[2 : len(name)-1]
+	}
+	return name
+}
+
+// splitNames splits a "、"-separated name list from a system notice
+func splitNames(names string) []string {
+	out := make([]string, 0, 1)
+	for _, name := range strings.Split(names, "、") {
+		if name = strings.Trim(strings.TrimSpace(name), `"`); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// ShareKindGroupInvite marks a share card inviting the recipient into a group
+const ShareKindGroupInvite = "group_invite"
+
+// "张三"邀请你加入群聊"技术交流群"，进入可查看详情。
+var groupInviteNameRegexp = regexp.MustCompile(`加入群聊\s*"([^"]+)"`)
+
+// isGroupInviteURL reports whether url is a WeChat group invitation link
+func isGroupInviteURL(url string) bool {
+	return strings.Contains(url, "addchatroombyinvite")
+}
+
+// groupInviteName extracts the group name from an invite card description
+func groupInviteName(desc string) string {
+	if m := groupInviteNameRegexp.FindStringSubmatch(desc); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// NameChange is a group rename found by ChatLab.NameChanges
+type NameChange struct {
+	Timestamp int64  `json:"timestamp"`
+	By        string `json:"by"`
+	NewName   string `json:"newName"`
+}
+
+// NameChanges lists the group renames announced by system messages, oldest
+// first, for a group history view
+func (cl ChatLab) NameChanges() []NameChange {
+	changes := make([]NameChange, 0)
+	for _, msg := range cl.Messages {
+		if msg.SystemKind != SystemKindNameChange {
+			continue
+		}
+		if notice, ok := parseSystemMessage(msg.Content); ok && notice.Kind == SystemKindNameChange {
+			changes = append(changes, NameChange{Timestamp: msg.Timestamp, By: notice.Actor, NewName: notice.Target})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Timestamp < changes[j].Timestamp
+	})
+	return changes
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSystemMessageJoin(t *testing.T) {
+	tests := []struct {
+		content string
+		want    systemNotice
+	}{
+		{`"张三"邀请"李四、王五"加入了群聊`, systemNotice{Kind: SystemKindJoinInvite, Actor: "张三", Target: "李四、王五"}},
+		{`你邀请"李四"加入了群聊`, systemNotice{Kind: SystemKindJoinInvite, Actor: "你", Target: "李四"}},
+		{`"张三"邀请你加入了群聊，群聊参与人还有：李四`, systemNotice{Kind: SystemKindJoinInvite, Actor: "张三", Target: "你"}},
+		{`"李四"通过扫描"张三"分享的二维码加入群聊`, systemNotice{Kind: SystemKindJoinQR, Actor: "张三", Target: "李四"}},
+		{`"李四"通过扫描你分享的二维码加入群聊`, systemNotice{Kind: SystemKindJoinQR, Actor: "你", Target: "李四"}},
+		{`你通过扫描二维码加入群聊`, systemNotice{Kind: SystemKindJoinQR, Target: "你"}},
+		{`"王五"通过扫描二维码加入了群聊`, systemNotice{Kind: SystemKindJoinQR, Target: "王五"}},
+		{`群管理员"张三"已将"李四"添加到群聊`, systemNotice{Kind: SystemKindJoinAdmin, Actor: "张三", Target: "李四"}},
+		{`群主"张三"将"李四、王五"拉进了群聊`, systemNotice{Kind: SystemKindJoinAdmin, Actor: "张三", Target: "李四、王五"}},
+	}
+	for _, tt := range tests {
+		got, ok := parseSystemMessage(tt.content)
+		if !ok || got != tt.want {
+			t.Errorf("parseSystemMessage(%q) = %+v, %v, want %+v", tt.content, got, ok, tt.want)
+		}
+	}
+
+	if got, ok := parseSystemMessage("群公告已更新"); ok {
+		t.Errorf("unexpected notice %+v", got)
+	}
+}
+
+func TestConvertToChatLabJoinNotice(t *testing.T) {
+	messages := []*Message{
+		{Sender: "系统消息", Type: MessageTypeSystem, Content: `"张三"邀请"李四、王五"加入了群聊`},
+	}
+
+	got := ConvertToChatLab(messages, "1@chatroom", "群").Messages[0]
+
+	if got.Type != ChatLabTypeSystem || got.SystemKind != SystemKindJoinInvite || got.Inviter != "张三" {
+		t.Errorf("message = %+v", got)
+	}
+	if !reflect.DeepEqual(got.Joined, []string{"李四", "王五"}) {
+		t.Errorf("joined = %v", got.Joined)
+	}
+}
+
+func TestConvertToChatLabGroupInvite(t *testing.T) {
+	url := "https://support.weixin.qq.com/cgi-bin/mmsupport-bin/addchatroombyinvite?ticket=AbCdEf"
+	msg := &Message{Type: MessageTypeShare, Sender: "a"}
+	if err := msg.ParseMediaInfo(`<msg><appmsg><title>邀请你加入群聊</title><des>"张三"邀请你加入群聊"技术交流群"，进入可查看详情。</des>` +
+		`<type>5</type><url>` + strings.ReplaceAll(url, "&", "&amp;") + `</url></appmsg></msg>`); err != nil {
+		t.Fatal(err)
+	}
+
+	got := ConvertToChatLab([]*Message{msg}, "a", "A").Messages[0]
+
+	if got.Type != ChatLabTypeShare || got.ShareKind != ShareKindGroupInvite {
+		t.Errorf("message = %+v", got)
+	}
+	if got.Share == nil || got.Share.Title != "技术交流群" || got.Share.URL != url {
+		t.Errorf("share = %+v", got.Share)
+	}
+
+	link := &Message{Type: MessageTypeShare, SubType: MessageSubTypeLink2, Sender: "a", Contents: map[string]interface{}{"title": "文章", "url": "https://mp.weixin.qq.com/s/x"}}
+	if got := ConvertToChatLab([]*Message{link}, "a", "A").Messages[0]; got.Type != ChatLabTypeLink || got.ShareKind != "" {
+		t.Errorf("plain link = %+v", got)
+	}
+}
+
+func TestChatLabNameChanges(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeSystem, Content: `"张三"修改群名为“周末爬山群”`},
+		{Time: time.Unix(200, 0), Sender: "b", Type: MessageTypeText, Content: "哈哈"},
+		{Time: time.Unix(300, 0), Sender: "me", Type: MessageTypeSystem, Content: `你修改群名为"爬山小分队"`},
+	}
+	opts := DefaultConvertOptions()
+	opts.Reverse = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	if kind := cl.Messages[0].SystemKind; kind != SystemKindNameChange {
+		t.Errorf("systemKind = %q, want %q", kind, SystemKindNameChange)
+	}
+	want := []NameChange{
+		{Timestamp: 100, By: "张三", NewName: "周末爬山群"},
+		{Timestamp: 300, By: "你", NewName: "爬山小分队"},
+	}
+	if got := cl.NameChanges(); !reflect.DeepEqual(got, want) {
+		t.Errorf("NameChanges() = %+v, want %+v", got, want)
+	}
+}
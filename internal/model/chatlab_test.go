@@ -0,0 +1,1870 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertToChatLabSystemPat(t *testing.T) {
+	messages := []*Message{
+		{
+			Time:    time.Unix(1703001600, 0),
+			Talker:  "123@chatroom",
+			Sender:  "系统消息",
+			Type:    MessageTypeSystem,
+			Content: `"张三" 拍了拍 "李四"`,
+		},
+		{
+			Time:    time.Unix(1703001610, 0),
+			Talker:  "123@chatroom",
+			Sender:  "系统消息",
+			Type:    MessageTypeSystem,
+			Content: `"张三" 邀请 "王五" 加入了群聊`,
+		},
+	}
+
+	cl := ConvertToChatLab(messages, "123@chatroom", "群聊")
+
+	pat := cl.Messages[0]
+	if pat.Type != ChatLabTypePoke {
+		t.Fatalf("pat type = %d, want %d", pat.Type, ChatLabTypePoke)
+	}
+	if pat.PatFrom != "张三" || pat.PatTo != "李四" {
+		t.Errorf("pat = %q -> %q, want 张三 -> 李四", pat.PatFrom, pat.PatTo)
+	}
+	if pat.Content != messages[0].Content {
+		t.Errorf("pat content = %q, want original sentence", pat.Content)
+	}
+
+	if sys := cl.Messages[1]; sys.Type != ChatLabTypeSystem || sys.PatFrom != "" {
+		t.Errorf("system message = %+v, want plain system", sys)
+	}
+}
+
+func TestParsePat(t *testing.T) {
+	tests := []struct {
+		content    string
+		wantFrom   string
+		wantTo     string
+		wantSuffix string
+		wantOk     bool
+	}{
+		{`"张三" 拍了拍 "李四"`, "张三", "李四", "", true},
+		{`我拍了拍"李四"的肩膀`, "我", "李四", "的肩膀", true},
+		{`"${wxid_a}" 拍了拍 "${wxid_b}"`, "wxid_a", "wxid_b", "", true},
+		{`张三 拍了拍 我`, "张三", "我", "", true},
+		{`"张三" 拍了拍我的狗头`, "张三", "我", "的狗头", true},
+		{`"张三" 拍了拍 "李四" 并说早上好`, "张三", "李四", "并说早上好", true},
+		{`"张三" 撤回了一条消息`, "", "", "", false},
+	}
+	for _, tt := range tests {
+		from, to, suffix, ok := parsePat(tt.content)
+		if from != tt.wantFrom || to != tt.wantTo || suffix != tt.wantSuffix || ok != tt.wantOk {
+			t.Errorf("parsePat(%q) = %q, %q, %q, %v; want %q, %q, %q, %v", tt.content, from, to, suffix, ok, tt.wantFrom, tt.wantTo, tt.wantSuffix, tt.wantOk)
+		}
+	}
+}
+
+func TestConvertToChatLabPatSuffix(t *testing.T) {
+	const content = `"张三" 拍了拍我的狗头`
+	messages := []*Message{{Sender: "a", Type: MessageTypeSystem, Content: content}}
+
+	pat := ConvertToChatLab(messages, "1@chatroom", "群").Messages[0]
+
+	if pat.Type != ChatLabTypePoke || pat.PatFrom != "张三" || pat.PatTo != "我" || pat.PatSuffix != "的狗头" {
+		t.Errorf("pat = %+v", pat)
+	}
+	if pat.Content != content {
+		t.Errorf("content = %q, want the full sentence", pat.Content)
+	}
+}
+
+func TestChatLabMediaRefs(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/1.jpg", "thumbpath": "msg/attach/1_t.jpg"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"md5": "0123456789abcdef0123456789abcdef"}},
+		{Sender: "b", Type: MessageTypeAnimation, Contents: map[string]interface{}{"cdnurl": "https://emoji.example.com/e.gif"}},
+		{Sender: "b", Type: MessageTypeVoice},
+		{Sender: "b", Type: MessageTypeText, Content: "msg/attach/1.jpg"},
+		{Sender: "a", Type: MessageTypeVideo, Contents: map[string]interface{}{"path": "msg/video/2024-01/v1", "md5": "fedcba9876543210fedcba9876543210"}},
+		{Sender: "a", Type: MessageTypeShare, SubType: MessageSubTypeFile, Contents: map[string]interface{}{"title": "report.pdf", "md5": "00112233445566778899aabbccddeeff"}},
+	}
+
+	refs := ConvertToChatLab(messages, "wxid_b", "B").MediaRefs()
+
+	want := []MediaRef{
+		{Index: 0, Type: ChatLabTypeImage, Ref: "msg/attach/1.jpg", Kind: MediaRefKindPath},
+		{Index: 0, Type: ChatLabTypeImage, Ref: "msg/attach/1_t.jpg", Kind: MediaRefKindPath, Thumb: true},
+		{Index: 1, Type: ChatLabTypeImage, Ref: "0123456789abcdef0123456789abcdef", Kind: MediaRefKindMD5},
+		{Index: 2, Type: ChatLabTypeEmoji, Ref: "https://emoji.example.com/e.gif", Kind: MediaRefKindURL},
+		{Index: 5, Type: ChatLabTypeVideo, Ref: "msg/video/2024-01/v1", Kind: MediaRefKindPath},
+		{Index: 6, Type: ChatLabTypeFile, Ref: "00112233445566778899aabbccddeeff", Kind: MediaRefKindMD5},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("got %d refs, want %d: %+v", len(refs), len(want), refs)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("refs[%d] = %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+}
+
+func TestConvertToChatLabIncludeSelf(t *testing.T) {
+	messages := []*Message{
+		{Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "您好，请问有什么可以帮您？"},
+		{Sender: "customer", SenderName: "客户", Type: MessageTypeText, Content: "订单还没发货"},
+		{Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "马上为您查询"},
+	}
+
+	all := ConvertToChatLab(messages, "customer", "客户")
+	if len(all.Messages) != 3 || len(all.Members) != 2 {
+		t.Fatalf("default: got %d messages, %d members; want 3, 2", len(all.Messages), len(all.Members))
+	}
+
+	opts := DefaultConvertOptions()
+	opts.IncludeSelf = false
+	others := ConvertToChatLabWithOptions(messages, "customer", "客户", opts)
+	if len(others.Messages) != 1 || others.Messages[0].Sender != "customer" {
+		t.Errorf("IncludeSelf=false: messages = %+v, want only customer", others.Messages)
+	}
+	if len(others.Members) != 1 || others.Members[0].PlatformID != "customer" {
+		t.Errorf("IncludeSelf=false: members = %+v, want only customer", others.Members)
+	}
+}
+
+func TestConvertToChatLabResolveMD5(t *testing.T) {
+	const md5 = "0123456789abcdef0123456789abcdef"
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"md5": md5}},
+	}
+
+	unresolved := ConvertToChatLab(messages, "a", "A").Messages[0]
+	if unresolved.Content != "[图片]" || unresolved.MD5 != md5 {
+		t.Errorf("without resolver: content = %q, md5 = %q", unresolved.Content, unresolved.MD5)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.ResolveMD5 = func(m string) (string, bool) {
+		if m == md5 {
+			return "/data/img/" + m + ".jpg", true
+		}
+		return "", false
+	}
+	resolved := ConvertToChatLabWithOptions(messages, "a", "A", opts).Messages[0]
+	if resolved.Content != "/data/img/"+md5+".jpg" || resolved.MD5 != md5 {
+		t.Errorf("with resolver: content = %q, md5 = %q", resolved.Content, resolved.MD5)
+	}
+
+	opts.ResolveMD5 = func(string) (string, bool) { return "", false }
+	failed := ConvertToChatLabWithOptions(messages, "a", "A", opts).Messages[0]
+	if failed.Content != "[图片]" || failed.MD5 != md5 {
+		t.Errorf("failed resolver: content = %q, md5 = %q", failed.Content, failed.MD5)
+	}
+}
+
+func TestConvertToChatLabDeterministicOutput(t *testing.T) {
+	messages := make([]*Message, 0)
+	for _, sender := range []string{"wxid_c", "wxid_a", "wxid_e", "wxid_b", "wxid_d", "wxid_a"} {
+		messages = append(messages, &Message{Sender: sender, SenderName: sender, Type: MessageTypeText, Content: "hi"})
+	}
+
+	for _, sortMembers := range []bool{false, true} {
+		opts := DefaultConvertOptions()
+		opts.SortMembers = sortMembers
+
+		var outputs [][]byte
+		for i := 0; i < 2; i++ {
+			cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+			cl.ChatLab.ExportedAt = 0
+			b, err := json.Marshal(cl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs = append(outputs, b)
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) {
+			t.Errorf("SortMembers=%v: output differs between runs", sortMembers)
+		}
+	}
+
+	opts := DefaultConvertOptions()
+	opts.SortMembers = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	for i, want := range []string{"wxid_a", "wxid_b", "wxid_c", "wxid_d", "wxid_e"} {
+		if cl.Members[i].PlatformID != want {
+			t.Errorf("members[%d] = %s, want %s", i, cl.Members[i].PlatformID, want)
+		}
+	}
+
+	cl = ConvertToChatLab(messages, "1@chatroom", "群")
+	for i, want := range []string{"wxid_c", "wxid_a", "wxid_e", "wxid_b", "wxid_d"} {
+		if cl.Members[i].PlatformID != want {
+			t.Errorf("first-appearance members[%d] = %s, want %s", i, cl.Members[i].PlatformID, want)
+		}
+	}
+}
+
+func TestConvertToChatLabRevoke(t *testing.T) {
+	messages := []*Message{
+		{Sender: "系统消息", Type: MessageTypeSystem, Content: `"张三" 撤回了一条消息`},
+		{Sender: "系统消息", Type: MessageTypeSystem, Content: `"管理员" 撤回了 "张三" 的一条消息`},
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	recall := cl.Messages[0]
+	if recall.Type != ChatLabTypeRecall || recall.SystemKind != SystemKindRecall || recall.RevokedBy != "" {
+		t.Errorf("recall = %+v, want self recall", recall)
+	}
+
+	revoke := cl.Messages[1]
+	if revoke.Type != ChatLabTypeRecall || revoke.SystemKind != SystemKindAdminRevoke {
+		t.Errorf("admin revoke = %+v, want admin_revoke recall", revoke)
+	}
+	if revoke.RevokedBy != "管理员" {
+		t.Errorf("RevokedBy = %q, want 管理员", revoke.RevokedBy)
+	}
+}
+
+func TestConvertToChatLabEmbedMedia(t *testing.T) {
+	files := map[string][]byte{
+		"small.png": []byte("\x89PNG"),
+		"large.jpg": bytes.Repeat([]byte{0xff}, 64),
+	}
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "small.png"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "large.jpg"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "missing.jpg"}},
+		{Sender: "a", Type: MessageTypeText, Content: "small.png"},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.EmbedMedia = true
+	opts.MaxEmbedBytes = 32
+	opts.LoadMedia = func(ref string) ([]byte, string, error) {
+		data, ok := files[ref]
+		if !ok {
+			return nil, "", errors.New("not found")
+		}
+		return data, "image/png", nil
+	}
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	want := []string{"data:image/png;base64,iVBORw==", "large.jpg", "missing.jpg", "small.png"}
+	for i, w := range want {
+		if cl.Messages[i].Content != w {
+			t.Errorf("messages[%d].Content = %q, want %q", i, cl.Messages[i].Content, w)
+		}
+	}
+}
+
+func TestConvertToChatLabMediaBaseURL(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/abc/Img/1.jpg"}},
+		{Sender: "a", Type: MessageTypeVideo, Contents: map[string]interface{}{"thumbpath": `msg\video\2 thumb.jpg`}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"md5": "0123456789abcdef0123456789abcdef"}},
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"cdnurl": "http://emoji.qpic.cn/wx_emoji/x"}},
+		{Sender: "a", Type: MessageTypeText, Content: "msg/attach/1.jpg"},
+	}
+	opts := DefaultConvertOptions()
+	opts.MediaBaseURL = "https://cdn.example.com/media/"
+
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if got := cl.Messages[0].Content; got != "https://cdn.example.com/media/1.jpg" {
+		t.Errorf("image content = %q", got)
+	}
+	if got := cl.Messages[1]; got.Thumb != "https://cdn.example.com/media/2%20thumb.jpg" || got.Content != "[视频]" {
+		t.Errorf("video = %q, thumb %q", got.Content, got.Thumb)
+	}
+	for i, want := range map[int]string{2: "[图片]", 3: "http://emoji.qpic.cn/wx_emoji/x", 4: "msg/attach/1.jpg"} {
+		if got := cl.Messages[i].Content; got != want {
+			t.Errorf("messages[%d].Content = %q, want %q untouched", i, got, want)
+		}
+	}
+}
+
+func TestConvertToChatLabNilContents(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgType  int64
+		subType  int64
+		wantType int
+		want     string
+	}{
+		{"image", MessageTypeImage, 0, ChatLabTypeImage, "[图片]"},
+		{"voice", MessageTypeVoice, 0, ChatLabTypeVoice, "[语音]"},
+		{"video", MessageTypeVideo, 0, ChatLabTypeVideo, "[视频]"},
+		{"emoji", MessageTypeAnimation, 0, ChatLabTypeEmoji, "[表情]"},
+		{"location", MessageTypeLocation, 0, ChatLabTypeLocation, "[位置]"},
+		{"card", MessageTypeCard, 0, ChatLabTypeContact, "[名片]"},
+		{"file", MessageTypeShare, MessageSubTypeFile, ChatLabTypeFile, "[文件]"},
+		{"link", MessageTypeShare, MessageSubTypeLink, ChatLabTypeLink, "[链接]"},
+		{"forward", MessageTypeShare, MessageSubTypeMergeForward, ChatLabTypeForward, "[合并转发]"},
+		{"miniprogram", MessageTypeShare, MessageSubTypeMiniProgram, ChatLabTypeShare, "[小程序]"},
+		{"music", MessageTypeShare, MessageSubTypeMusic, ChatLabTypeShare, "[音乐]"},
+		{"redpacket", MessageTypeShare, MessageSubTypeRedEnvelope, ChatLabTypeRedPacket, "[红包]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{Sender: "a", Type: tt.msgType, SubType: tt.subType}
+			got := ConvertToChatLab([]*Message{msg}, "a", "A").Messages[0]
+			if got.Type != tt.wantType || got.Content != tt.want {
+				t.Errorf("got type %d content %q, want %d %q", got.Type, got.Content, tt.wantType, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertToGenericChatJSON(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(1703001600, 0), Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "在吗？"},
+		{Time: time.Unix(1703001610, 0), Sender: "xm", SenderName: "小明", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "img/1.jpg"}},
+		{Time: time.Unix(1703001620, 0), Sender: "xm", SenderName: "小明", Type: MessageTypeVoice},
+	}
+
+	b, err := ConvertToGenericChatJSON(ConvertToChatLab(messages, "xm", "小明"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gc GenericChat
+	if err := json.Unmarshal(b, &gc); err != nil {
+		t.Fatal(err)
+	}
+
+	if gc.Title != "小明" || len(gc.Participants) != 2 || len(gc.Messages) != 3 {
+		t.Fatalf("unexpected chat: %+v", gc)
+	}
+	if m := gc.Messages[0]; !m.IsFromMe || m.Text != "在吗？" || m.TimestampMs != 1703001600000 || len(m.Attachments) != 0 {
+		t.Errorf("messages[0] = %+v", m)
+	}
+	if m := gc.Messages[1]; m.IsFromMe || len(m.Attachments) != 1 || m.Attachments[0] != (GenericAttachment{Kind: "image", Ref: "img/1.jpg"}) {
+		t.Errorf("messages[1] = %+v", m)
+	}
+	if m := gc.Messages[2]; len(m.Attachments) != 1 || m.Attachments[0].Kind != "audio" || m.Attachments[0].Ref != "" || m.Text != "[语音]" {
+		t.Errorf("messages[2] = %+v", m)
+	}
+}
+
+func TestChatLabLastMessagePreview(t *testing.T) {
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Type: ChatLabTypeText, Content: "今天晚上一起吃饭吗？"},
+		{Type: ChatLabTypeImage, Content: "img/1.jpg"},
+		{Type: ChatLabTypeSystem, Content: `"张三" 加入了群聊`},
+	}}
+	if got := cl.LastMessagePreview(10); got != "[图片]" {
+		t.Errorf("preview = %q, want [图片]", got)
+	}
+
+	cl.Messages = cl.Messages[:1]
+	if got := cl.LastMessagePreview(5); got != "今天晚上…" {
+		t.Errorf("truncated preview = %q, want 今天晚上…", got)
+	}
+	if got := cl.LastMessagePreview(0); got != "今天晚上一起吃饭吗？" {
+		t.Errorf("untruncated preview = %q", got)
+	}
+
+	if got := (ChatLab{}).LastMessagePreview(10); got != "" {
+		t.Errorf("empty preview = %q", got)
+	}
+	if ChatLabTypeName(ChatLabTypePoke) != "POKE" || ChatLabTypeName(12345) != "OTHER" {
+		t.Errorf("ChatLabTypeName mismatch")
+	}
+}
+
+func TestConvertToChatLabResolveStickerMD5(t *testing.T) {
+	const md5 = "fedcba9876543210fedcba9876543210"
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": md5, "cdnurl": "http://emoji.qpic.cn/1"}},
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": "00000000000000000000000000000000", "cdnurl": "http://emoji.qpic.cn/2"}},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.ResolveMD5 = func(m string) (string, bool) {
+		return "stickers/" + m + ".gif", m == md5
+	}
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if got := cl.Messages[0]; got.Content != "stickers/"+md5+".gif" || got.CDNUrl != "http://emoji.qpic.cn/1" || got.MD5 != md5 {
+		t.Errorf("resolved sticker = %+v", got)
+	}
+	if got := cl.Messages[1]; got.Content != "http://emoji.qpic.cn/2" || got.CDNUrl != "http://emoji.qpic.cn/2" {
+		t.Errorf("unresolved sticker = %+v", got)
+	}
+}
+
+func TestConvertToChatLabSelfNameDefaults(t *testing.T) {
+	messages := []*Message{{Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "hi"}}
+
+	tests := []struct {
+		platform string
+		selfName string
+		want     string
+	}{
+		{"wechat", "", "我"},
+		{"", "", "我"},
+		{"qq", "", "Me"},
+		{"discord", "", "Me"},
+		{"qq", "本人", "本人"},
+	}
+	for _, tt := range tests {
+		opts := DefaultConvertOptions()
+		opts.Platform = tt.platform
+		opts.SelfName = tt.selfName
+		cl := ConvertToChatLabWithOptions(messages, "friend", "Friend", opts)
+		if got := cl.Messages[0].AccountName; got != tt.want {
+			t.Errorf("platform %q selfName %q: account name = %q, want %q", tt.platform, tt.selfName, got, tt.want)
+		}
+		if tt.platform != "" && cl.Meta.Platform != tt.platform {
+			t.Errorf("meta.platform = %q, want %q", cl.Meta.Platform, tt.platform)
+		}
+	}
+}
+
+func TestConvertToChatLabAlwaysUseSelfName(t *testing.T) {
+	messages := []*Message{
+		{Sender: "me", SenderName: "我的iPad", IsSelf: true, Type: MessageTypeText, Content: "平板发的"},
+		{Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "手机发的"},
+		{Sender: "friend", SenderName: "Friend", Type: MessageTypeText, Content: "收到"},
+	}
+
+	tests := []struct {
+		always bool
+		want   []string
+	}{
+		{false, []string{"我的iPad", "我", "Friend"}},
+		{true, []string{"我", "我", "Friend"}},
+	}
+	for _, tt := range tests {
+		opts := DefaultConvertOptions()
+		opts.AlwaysUseSelfName = tt.always
+		cl := ConvertToChatLabWithOptions(messages, "friend", "Friend", opts)
+		for i, want := range tt.want {
+			if got := cl.Messages[i].AccountName; got != want {
+				t.Errorf("always %v: messages[%d].AccountName = %q, want %q", tt.always, i, got, want)
+			}
+		}
+	}
+}
+
+func TestConvertToChatLabReactions(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "周五团建", Contents: map[string]interface{}{
+			"reactions": []interface{}{
+				map[string]interface{}{"emoji": "👍", "by": []interface{}{"wxid_b", "wxid_c"}},
+				map[string]interface{}{"emoji": "🎉", "users": []string{"wxid_d"}},
+			},
+		}},
+		{Sender: "b", Type: MessageTypeText, Content: "好"},
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	got := cl.Messages[0].Reactions
+	if len(got) != 2 {
+		t.Fatalf("reactions = %+v, want 2", got)
+	}
+	if got[0].Emoji != "👍" || len(got[0].By) != 2 || got[0].By[1] != "wxid_c" {
+		t.Errorf("reactions[0] = %+v", got[0])
+	}
+	if got[1].Emoji != "🎉" || len(got[1].By) != 1 || got[1].By[0] != "wxid_d" {
+		t.Errorf("reactions[1] = %+v", got[1])
+	}
+	if cl.Messages[1].Reactions != nil {
+		t.Errorf("message without reactions got %+v", cl.Messages[1].Reactions)
+	}
+
+	b, _ := json.Marshal(cl.Messages[1])
+	if bytes.Contains(b, []byte("reactions")) {
+		t.Errorf("reactions should be omitted: %s", b)
+	}
+}
+
+func TestConvertToChatLabDropEmpty(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "你好"},
+		{Sender: "b", Type: MessageTypeText, Content: "  \n"},
+		{Sender: "c", Type: 9999},
+		{Sender: "a", Type: MessageTypeVoice},
+	}
+
+	if got := len(ConvertToChatLab(messages, "1@chatroom", "群").Messages); got != 4 {
+		t.Errorf("default kept %d messages, want 4", got)
+	}
+
+	var stats ConvertStats
+	opts := DefaultConvertOptions()
+	opts.DropEmpty = true
+	opts.Stats = &stats
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	if len(cl.Messages) != 2 || cl.Messages[0].Content != "你好" || cl.Messages[1].Type != ChatLabTypeVoice {
+		t.Errorf("messages = %+v, want text and voice", cl.Messages)
+	}
+	if len(cl.Members) != 1 {
+		t.Errorf("members = %+v, want only a", cl.Members)
+	}
+	if !reflect.DeepEqual(stats, ConvertStats{Input: 4, Output: 2, DroppedEmpty: 2, UnmappedTypes: map[int]int{9999: 1}}) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestConvertMessage(t *testing.T) {
+	msg := &Message{
+		Time:     time.Unix(1703001600, 0),
+		Sender:   "me",
+		IsSelf:   true,
+		Type:     MessageTypeImage,
+		Contents: map[string]interface{}{"path": "img/1.jpg"},
+	}
+
+	got := ConvertMessage(msg, true, "本人")
+	want := ChatLabMessage{
+		Sender:      "me",
+		AccountName: "本人",
+		Timestamp:   1703001600,
+		Type:        ChatLabTypeImage,
+		Content:     "img/1.jpg",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConvertMessage = %+v, want %+v", got, want)
+	}
+
+	if got := ConvertMessage(msg, false, ""); got.AccountName != "我" || got.GroupNickname != "" {
+		t.Errorf("private ConvertMessage = %+v", got)
+	}
+
+	if typ, content := MapMessageType(msg); typ != ChatLabTypeImage || content != "img/1.jpg" {
+		t.Errorf("MapMessageType = %d, %q", typ, content)
+	}
+
+	cl := ConvertToChatLab([]*Message{msg}, "1@chatroom", "群")
+	if !reflect.DeepEqual(cl.Messages[0], ConvertMessage(msg, true, "")) {
+		t.Errorf("ConvertToChatLab and ConvertMessage disagree")
+	}
+}
+
+func TestConvertToChatLabShareSource(t *testing.T) {
+	msg := &Message{Sender: "a", Type: MessageTypeShare}
+	if err := msg.ParseMediaInfo(`<msg><appmsg><type>5</type><title>【4K】城市夜景</title><url>https://b23.tv/abc</url><sourcedisplayname>哔哩哔哩</sourcedisplayname></appmsg></msg>`); err != nil {
+		t.Fatal(err)
+	}
+	plain := &Message{Sender: "a", Type: MessageTypeShare, SubType: MessageSubTypeLink, Contents: map[string]interface{}{"url": "https://example.com"}}
+
+	cl := ConvertToChatLab([]*Message{msg, plain}, "a", "A")
+
+	if got := cl.Messages[0]; got.Type != ChatLabTypeLink || got.Source != "哔哩哔哩" {
+		t.Errorf("share = %+v, want link via 哔哩哔哩", got)
+	}
+	b, _ := json.Marshal(cl.Messages[1])
+	if bytes.Contains(b, []byte(`"source"`)) {
+		t.Errorf("source should be omitted: %s", b)
+	}
+}
+
+func TestConvertToChatLabArticleShare(t *testing.T) {
+	article := &Message{Sender: "a", Type: MessageTypeShare, SubType: MessageSubTypeLink, Contents: map[string]interface{}{
+		"title":   "从零实现一个数据库",
+		"url":     "https://mp.weixin.qq.com/s/abc",
+		"author":  "老张",
+		"pubtime": "1700000000",
+	}}
+
+	got := ConvertToChatLab([]*Message{article}, "a", "A").Messages[0].Share
+	want := &ChatLabShare{Title: "从零实现一个数据库", URL: "https://mp.weixin.qq.com/s/abc", Author: "老张", PublishedAt: 1700000000}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("share = %+v, want %+v", got, want)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.TimestampUnit = TimestampUnitMillis
+	if ms := ConvertToChatLabWithOptions([]*Message{article}, "a", "A", opts).Messages[0].Share.PublishedAt; ms != 1700000000000 {
+		t.Errorf("millisecond publishedAt = %d", ms)
+	}
+}
+
+func TestConvertToChatLabOmitMediaPlaceholder(t *testing.T) {
+	messages := []*Message{
+		transferMessage(t, 100, "a", 1, "￥66.00", "奶茶", ""),
+		{Sender: "a", Type: MessageTypeLocation, Contents: map[string]interface{}{"x": "39.9", "y": "116.4", "poiname": "天安门"}},
+		{Sender: "a", Type: MessageTypeText, Content: "你好"},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"md5": "aaa"}},
+		{Sender: "a", Type: MessageTypeLocation, Contents: map[string]interface{}{"label": "某处"}},
+		{Sender: "a", Type: MessageTypeLocation, Contents: map[string]interface{}{"x": "31.2", "y": "121.5"}},
+	}
+	opts := DefaultConvertOptions()
+	opts.OmitMediaPlaceholder = true
+
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	for i, want := range []string{"", "天安门", "你好", "[图片]", "某处", ""} {
+		if got := cl.Messages[i].Content; got != want {
+			t.Errorf("messages[%d].Content = %q, want %q", i, got, want)
+		}
+	}
+	if cl.Messages[0].Payment == nil || cl.Messages[1].Latitude != "39.9" {
+		t.Errorf("structured fields should be kept: %+v", cl.Messages[:2])
+	}
+	if got := cl.Rows()[1].LocationName; got != "天安门" {
+		t.Errorf("row location name = %q", got)
+	}
+	if got := ConvertToChatLab(messages, "a", "A").Messages[5].Content; got != "[位置]" {
+		t.Errorf("default content = %q", got)
+	}
+}
+
+func TestConvertToChatLabOfficialAccount(t *testing.T) {
+	messages := []*Message{
+		{Sender: "gh_3dfda90e39d6", SenderName: "某某日报", Type: MessageTypeText, Content: "今日要闻"},
+		{Sender: "wxid_a", SenderName: "A", Type: MessageTypeText, Content: "收到"},
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	if !cl.Messages[0].Bot || cl.Messages[1].Bot {
+		t.Errorf("bot flags = %v, %v", cl.Messages[0].Bot, cl.Messages[1].Bot)
+	}
+	if !cl.Members[0].IsOfficialAccount || cl.Members[1].IsOfficialAccount {
+		t.Errorf("members = %+v", cl.Members)
+	}
+	if b, _ := json.Marshal(cl.Messages[1]); bytes.Contains(b, []byte("bot")) {
+		t.Errorf("bot should be omitted for people: %s", b)
+	}
+}
+
+func TestConvertToChatLabMentionsAll(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "@所有人 下午三点开会", Contents: map[string]interface{}{"atuserlist": "notify@all, b"}},
+		{Sender: "a", Type: MessageTypeText, Content: "@所有人 记得带电脑"},
+		{Sender: "b", Type: MessageTypeText, Content: "@A 收到", Contents: map[string]interface{}{"atuserlist": []interface{}{"a"}}},
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+
+	if m := cl.Messages[0]; !m.MentionsAll || !reflect.DeepEqual(m.Mentions, []string{"b"}) {
+		t.Errorf("at-all message: mentionsAll=%v mentions=%v", m.MentionsAll, m.Mentions)
+	}
+	if m := cl.Messages[1]; !m.MentionsAll || m.Mentions != nil {
+		t.Errorf("text at-all: mentionsAll=%v mentions=%v", m.MentionsAll, m.Mentions)
+	}
+	if m := cl.Messages[2]; m.MentionsAll || !reflect.DeepEqual(m.Mentions, []string{"a"}) {
+		t.Errorf("individual mention: mentionsAll=%v mentions=%v", m.MentionsAll, m.Mentions)
+	}
+	if b, _ := json.Marshal(cl.Messages[2]); bytes.Contains(b, []byte("mentionsAll")) {
+		t.Errorf("mentionsAll should be omitted: %s", b)
+	}
+}
+
+func TestConvertToChatLabSynthesizeMissingIDs(t *testing.T) {
+	messages := []*Message{
+		{SenderName: "张三", Type: MessageTypeText, Content: "1"},
+		{SenderName: "李四", Type: MessageTypeText, Content: "2"},
+		{SenderName: "张三", Type: MessageTypeText, Content: "3"},
+	}
+
+	if got := ConvertToChatLab(messages, "1@chatroom", "群").Members; len(got) != 1 || got[0].PlatformID != "" {
+		t.Errorf("default members = %+v, want one empty-keyed member", got)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.SynthesizeMissingIDs = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	want := []ChatLabMember{{PlatformID: "name:张三", AccountName: "张三"}, {PlatformID: "name:李四", AccountName: "李四"}}
+	if !reflect.DeepEqual(cl.Members, want) {
+		t.Errorf("members = %+v, want %+v", cl.Members, want)
+	}
+	if cl.Messages[2].Sender != "name:张三" || messages[0].Sender != "" {
+		t.Errorf("messages[2].Sender = %q, input sender = %q", cl.Messages[2].Sender, messages[0].Sender)
+	}
+}
+
+func TestConvertToChatLabContentRegex(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "周五发布 v1.2"},
+		{Sender: "b", Type: MessageTypeText, Content: "收到"},
+		{Sender: "c", Type: MessageTypeText, Content: "v1.3 下周"},
+		{Sender: "b", Type: MessageTypeImage},
+	}
+	opts := DefaultConvertOptions()
+	var stats ConvertStats
+	opts.Stats = &stats
+	opts.ContentRegex = regexp.MustCompile(`v\d+\.\d+`)
+
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	if len(cl.Messages) != 2 || cl.Messages[0].Content != "周五发布 v1.2" || cl.Messages[1].Content != "v1.3 下周" {
+		t.Errorf("messages = %+v", cl.Messages)
+	}
+	if len(cl.Members) != 2 || cl.Members[0].PlatformID != "a" || cl.Members[1].PlatformID != "c" {
+		t.Errorf("members = %+v", cl.Members)
+	}
+	if stats.DroppedRegex != 2 {
+		t.Errorf("DroppedRegex = %d, want 2", stats.DroppedRegex)
+	}
+
+	opts.Stats = nil
+	opts.ContentRegex = regexp.MustCompile(`^\[图片\]$`)
+	if got := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts).Messages; len(got) != 1 || got[0].Sender != "b" {
+		t.Errorf("placeholder match = %+v", got)
+	}
+}
+
+func TestConvertToChatLabContentRegexOmitPlaceholder(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeLocation, Contents: map[string]interface{}{"x": "31.2", "y": "121.5"}},
+		{Sender: "b", Type: MessageTypeText, Content: "到了"},
+	}
+	opts := DefaultConvertOptions()
+	opts.OmitMediaPlaceholder = true
+	opts.ContentRegex = regexp.MustCompile(`^\[位置\]$`)
+
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	if len(cl.Messages) != 1 || cl.Messages[0].Sender != "a" {
+		t.Fatalf("messages = %+v, want the location kept", cl.Messages)
+	}
+	if got := cl.Messages[0].Content; got != "" {
+		t.Errorf("content = %q, want the placeholder omitted after matching", got)
+	}
+}
+
+func TestConvertToChatLabDualTimestamps(t *testing.T) {
+	messages := []*Message{{Time: time.Unix(1700000000, 0), Sender: "a", Type: MessageTypeText, Content: "hi"}}
+	opts := DefaultConvertOptions()
+	opts.Now = func() time.Time { return time.Unix(1700003600, 0) }
+
+	if b, _ := json.Marshal(ConvertToChatLabWithOptions(messages, "a", "A", opts)); bytes.Contains(b, []byte("ISO")) {
+		t.Errorf("ISO timestamps should be opt-in: %s", b)
+	}
+
+	opts.DualTimestamps = true
+	for _, unit := range []string{TimestampUnitSeconds, TimestampUnitMillis} {
+		opts.TimestampUnit = unit
+		cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+		for _, ts := range []struct {
+			num int64
+			iso string
+		}{{cl.Messages[0].Timestamp, cl.Messages[0].TimestampISO}, {cl.ChatLab.ExportedAt, cl.ChatLab.ExportedAtISO}} {
+			parsed, err := time.Parse(time.RFC3339, ts.iso)
+			if err != nil {
+				t.Fatalf("unit %q: %v", unit, err)
+			}
+			want := parsed.Unix()
+			if unit == TimestampUnitMillis {
+				want = parsed.UnixMilli()
+			}
+			if ts.num != want {
+				t.Errorf("unit %q: %d and %q disagree", unit, ts.num, ts.iso)
+			}
+		}
+	}
+}
+
+func TestConvertToChatLabConversationID(t *testing.T) {
+	messages := []*Message{{Sender: "a", Type: MessageTypeText, Content: "hi"}}
+
+	before := ConvertToChatLab(messages, "1@chatroom", "周末爬山群").Meta.ConversationID
+	after := ConvertToChatLab(messages, "1@chatroom", "爬山小分队").Meta.ConversationID
+	if len(before) != 32 || before != after {
+		t.Errorf("conversation IDs = %q, %q, want one stable 32-char ID", before, after)
+	}
+	if other := ConvertToChatLab(messages, "2@chatroom", "周末爬山群").Meta.ConversationID; other == before {
+		t.Error("different talkers share a conversation ID")
+	}
+	opts := DefaultConvertOptions()
+	opts.Platform = "qq"
+	if qq := ConvertToChatLabWithOptions(messages, "1@chatroom", "周末爬山群", opts).Meta.ConversationID; qq == before {
+		t.Error("different platforms share a conversation ID")
+	}
+}
+
+func TestConvertToChatLabUnescapeHTML(t *testing.T) {
+	const escaped = "Tom &amp; Jerry &lt;3 &quot;hi&quot; &#20320;&#22909;"
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: escaped},
+		{Sender: "a", Type: MessageTypeShare, SubType: MessageSubTypeLink, Contents: map[string]interface{}{"title": "a &amp; b", "url": "https://example.com/?a=1&amp;b=2"}},
+	}
+	opts := DefaultConvertOptions()
+	opts.UnescapeHTML = true
+	opts.KeepOriginalContent = true
+
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if got := cl.Messages[0]; got.Content != `Tom & Jerry <3 "hi" 你好` || got.OriginalContent != escaped {
+		t.Errorf("text = %q (original %q)", got.Content, got.OriginalContent)
+	}
+	if got := cl.Messages[1].Share; got == nil || got.URL != "https://example.com/?a=1&amp;b=2" {
+		t.Errorf("share URL should be untouched: %+v", got)
+	}
+	if got := ConvertToChatLab(messages, "a", "A").Messages[0].Content; got != escaped {
+		t.Errorf("default content = %q", got)
+	}
+}
+
+func TestConvertToChatLabLastN(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeText, Content: "1"},
+		{Time: time.Unix(200, 0), Sender: "b", Type: MessageTypeText, Content: "2"},
+		{Time: time.Unix(300, 0), Sender: "c", Type: MessageTypeText, Content: "3"},
+		{Time: time.Unix(400, 0), Sender: "b", Type: MessageTypeText, Content: "4"},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.LastN = 2
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	if len(cl.Messages) != 2 || cl.Messages[0].Content != "3" || cl.Messages[1].Content != "4" {
+		t.Errorf("messages = %+v, want last two", cl.Messages)
+	}
+	if len(cl.Members) != 2 || cl.Members[0].PlatformID != "c" || cl.Members[1].PlatformID != "b" {
+		t.Errorf("members = %+v, want c and b", cl.Members)
+	}
+
+	opts.LastN = 10
+	if got := len(ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts).Messages); got != 4 {
+		t.Errorf("LastN larger than total kept %d messages", got)
+	}
+}
+
+func TestConvertToChatLabNormalizeSystemSender(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", SenderName: "张三", Type: MessageTypeText, Content: "hi"},
+		{Sender: "系统消息", Type: MessageTypeSystem, Content: `"张三" 邀请 "李四" 加入了群聊`},
+		{Sender: "", Type: MessageTypeSystem, Content: `"李四" 撤回了一条消息`},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.NormalizeSystemSender = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	for _, i := range []int{1, 2} {
+		if m := cl.Messages[i]; m.Sender != ChatLabSystemSenderID || m.AccountName != DefaultSystemName {
+			t.Errorf("messages[%d] = %+v, want synthetic system sender", i, m)
+		}
+	}
+	if len(cl.Members) != 2 || cl.Members[1].PlatformID != ChatLabSystemSenderID || cl.Members[1].AccountName != "系统" {
+		t.Errorf("members = %+v, want a and system", cl.Members)
+	}
+
+	opts.SystemName = "System"
+	cl = ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	if cl.Messages[1].AccountName != "System" {
+		t.Errorf("localized system name = %q", cl.Messages[1].AccountName)
+	}
+
+	if got := len(ConvertToChatLab(messages, "1@chatroom", "群").Members); got != 3 {
+		t.Errorf("default members = %d, want 3", got)
+	}
+}
+
+func TestConvertToChatLabLocationPOI(t *testing.T) {
+	poi := &Message{Sender: "a", Type: MessageTypeLocation}
+	if err := poi.ParseMediaInfo(`<msg><location x="31.2304" y="121.4737" label="上海市黄浦区南京东路100号" poiname="星巴克(南京东路店)" /></msg>`); err != nil {
+		t.Fatal(err)
+	}
+	labelOnly := &Message{Sender: "a", Type: MessageTypeLocation, Contents: map[string]interface{}{"label": "上海市黄浦区"}}
+
+	cl := ConvertToChatLab([]*Message{poi, labelOnly}, "a", "A")
+
+	if got := cl.Messages[0]; got.Content != "星巴克(南京东路店)" || got.Address != "上海市黄浦区南京东路100号" {
+		t.Errorf("poi location = %+v", got)
+	}
+	if got := cl.Messages[1]; got.Content != "上海市黄浦区" || got.Address != "" {
+		t.Errorf("label-only location = %+v", got)
+	}
+}
+
+func TestConvertToChatLabReverse(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeText, Content: "1"},
+		{Time: time.Unix(200, 0), Sender: "b", Type: MessageTypeText, Content: "2"},
+		{Time: time.Unix(300, 0), Sender: "a", Type: MessageTypeText, Content: "3"},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.Reverse = true
+	opts.AssignSeq = true
+	cl := ConvertToChatLabWithOptions(messages, "b", "B", opts)
+	for i, want := range []struct {
+		content string
+		seq     int
+	}{{"3", 3}, {"2", 2}, {"1", 1}} {
+		if m := cl.Messages[i]; m.Content != want.content || m.Seq != want.seq {
+			t.Errorf("chronological seq: messages[%d] = %q/%d, want %q/%d", i, m.Content, m.Seq, want.content, want.seq)
+		}
+	}
+	if len(cl.Members) != 2 || cl.Members[0].PlatformID != "a" {
+		t.Errorf("members = %+v", cl.Members)
+	}
+
+	// Members keep first-appearance order of the chronological conversation
+	messages[2].Sender = "c"
+	cl = ConvertToChatLabWithOptions(messages, "b", "B", opts)
+	for i, want := range []string{"a", "b", "c"} {
+		if cl.Members[i].PlatformID != want {
+			t.Errorf("members[%d] = %s, want %s", i, cl.Members[i].PlatformID, want)
+		}
+	}
+
+	opts.ReverseSeq = true
+	cl = ConvertToChatLabWithOptions(messages, "b", "B", opts)
+	for i, m := range cl.Messages {
+		if m.Seq != i+1 {
+			t.Errorf("reversed seq: messages[%d].Seq = %d, want %d", i, m.Seq, i+1)
+		}
+	}
+	if cl.Messages[0].Content != "3" {
+		t.Errorf("reversed order lost: %+v", cl.Messages)
+	}
+}
+
+func TestConvertToChatLabIncludeSource(t *testing.T) {
+	const raw = `<msg><appmsg><type>5</type><title>文章</title><url>https://example.com/a</url></appmsg></msg>`
+	share := &Message{Sender: "a", Type: MessageTypeShare}
+	if err := share.ParseMediaInfo(raw); err != nil {
+		t.Fatal(err)
+	}
+	text := &Message{Sender: "a", Type: MessageTypeText}
+	if err := text.ParseMediaInfo("hello"); err != nil {
+		t.Fatal(err)
+	}
+	messages := []*Message{share, text}
+
+	if got := ConvertToChatLab(messages, "a", "A").Messages[0].SourceXML; got != "" {
+		t.Errorf("default SourceXML = %q, want empty", got)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.IncludeSource = true
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+	if cl.Messages[0].SourceXML != raw {
+		t.Errorf("SourceXML = %q, want raw appmsg", cl.Messages[0].SourceXML)
+	}
+	if cl.Messages[1].SourceXML != "" {
+		t.Errorf("text SourceXML = %q, want empty", cl.Messages[1].SourceXML)
+	}
+	b, _ := json.Marshal(cl.Messages[0])
+	if !bytes.Contains(b, []byte(`"_source":`)) {
+		t.Errorf("missing _source field: %s", b)
+	}
+}
+
+func TestConvertToChatLabMinContentRunes(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "嗯"},
+		{Sender: "b", Type: MessageTypeText, Content: "这个方案我觉得可以"},
+		{Sender: "a", Type: MessageTypeText, Content: " 哦 "},
+		{Sender: "b", Type: MessageTypeText, Content: "好的"},
+		{Sender: "a", Type: MessageTypeImage},
+	}
+
+	var stats ConvertStats
+	opts := DefaultConvertOptions()
+	opts.MinContentRunes = 2
+	opts.Stats = &stats
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	got := make([]string, 0)
+	for _, m := range cl.Messages {
+		got = append(got, m.Content)
+	}
+	want := []string{"这个方案我觉得可以", "好的", "[图片]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("contents = %q, want %q", got, want)
+	}
+	if stats.DroppedShort != 2 || stats.Output != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestConvertToChatLabContactAvatar(t *testing.T) {
+	const avatarURL = "https://wx.qlogo.cn/mmhead/ver_1/abc/0"
+	card := &Message{Sender: "a", Type: MessageTypeCard}
+	if err := card.ParseMediaInfo(`<msg username="wxid_friend" nickname="小明" alias="xiaoming" bigheadimgurl="` + avatarURL + `" />`); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := ConvertToChatLab([]*Message{card}, "a", "A").Messages[0]
+	if plain.Contact == nil || plain.Contact.Username != "wxid_friend" || plain.Contact.Nickname != "小明" || plain.Contact.AvatarURL != avatarURL {
+		t.Fatalf("contact = %+v", plain.Contact)
+	}
+	if plain.Contact.Avatar != "" {
+		t.Errorf("unresolved avatar = %q, want empty", plain.Contact.Avatar)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.ResolveMD5 = func(ref string) (string, bool) {
+		if ref == avatarURL {
+			return "avatars/wxid_friend.jpg", true
+		}
+		return "", false
+	}
+	resolved := ConvertToChatLabWithOptions([]*Message{card}, "a", "A", opts).Messages[0]
+	if resolved.Contact.Avatar != "avatars/wxid_friend.jpg" {
+		t.Errorf("resolved avatar = %q", resolved.Contact.Avatar)
+	}
+}
+
+func TestConvertToChatLabResolveAvatar(t *testing.T) {
+	base := t.TempDir()
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "1"},
+		{Sender: "b", Type: MessageTypeText, Content: "2"},
+		{Sender: "c", Type: MessageTypeText, Content: "3"},
+	}
+	avatars := map[string]string{"a": "avatars/a.jpg", "b": "https://wx.qlogo.cn/mmhead/b/0", "1@chatroom": "avatars/group.jpg"}
+	opts := DefaultConvertOptions()
+	opts.ResolveAvatar = func(id string) string { return avatars[id] }
+
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	for i, want := range []string{"avatars/a.jpg", "https://wx.qlogo.cn/mmhead/b/0", ""} {
+		if got := cl.Members[i].Avatar; got != want {
+			t.Errorf("members[%d].Avatar = %q, want %q", i, got, want)
+		}
+	}
+	if cl.Meta.GroupAvatar != "avatars/group.jpg" {
+		t.Errorf("group avatar = %q", cl.Meta.GroupAvatar)
+	}
+
+	opts.AvatarBaseDir = base
+	cl = ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	if want := filepath.Join(base, "avatars", "a.jpg"); cl.Members[0].Avatar != want {
+		t.Errorf("normalized avatar = %q, want %q", cl.Members[0].Avatar, want)
+	}
+	if cl.Members[1].Avatar != avatars["b"] {
+		t.Errorf("remote avatar = %q", cl.Members[1].Avatar)
+	}
+	if want := filepath.Join(base, "avatars", "group.jpg"); cl.Meta.GroupAvatar != want {
+		t.Errorf("normalized group avatar = %q, want %q", cl.Meta.GroupAvatar, want)
+	}
+}
+
+func TestConvertToChatLabStripAvatars(t *testing.T) {
+	card := &Message{Sender: "a", Type: MessageTypeCard}
+	if err := card.ParseMediaInfo(`<msg username="wxid_friend" nickname="小明" bigheadimgurl="https://wx.qlogo.cn/mmhead/ver_1/abc/0" />`); err != nil {
+		t.Fatal(err)
+	}
+	messages := []*Message{card, {Sender: "b", Type: MessageTypeText, Content: "你好"}}
+	opts := DefaultConvertOptions()
+	opts.ResolveMD5 = func(string) (string, bool) { return "avatars/wxid_friend.jpg", true }
+	opts.ResolveAvatar = func(id string) string { return "https://wx.qlogo.cn/mmhead/" + id + "/0" }
+
+	kept := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	if c := kept.Messages[0].Contact; c.AvatarURL == "" || c.Avatar == "" {
+		t.Fatalf("avatars should be kept by default: %+v", c)
+	}
+	if kept.Meta.GroupAvatar == "" || kept.Members[0].Avatar == "" || kept.Members[1].Avatar == "" {
+		t.Fatalf("member and group avatars should be kept by default: %+v %+v", kept.Meta, kept.Members)
+	}
+
+	opts.StripAvatars = true
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	stripped := cl.Messages[0].Contact
+	if stripped.AvatarURL != "" || stripped.Avatar != "" {
+		t.Errorf("stripped contact = %+v", stripped)
+	}
+	if stripped.Username != "wxid_friend" || stripped.Nickname != "小明" {
+		t.Errorf("contact details should survive: %+v", stripped)
+	}
+	if len(cl.Members) != 2 {
+		t.Fatalf("members = %+v", cl.Members)
+	}
+	for _, m := range cl.Members {
+		if m.Avatar != "" {
+			t.Errorf("member %s avatar = %q", m.PlatformID, m.Avatar)
+		}
+	}
+	if cl.Meta.GroupAvatar != "" {
+		t.Errorf("group avatar = %q", cl.Meta.GroupAvatar)
+	}
+}
+
+func TestConvertToChatLabEdits(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(300, 0), Sender: "a", Type: MessageTypeText, Content: "明天下午三点开会", Contents: map[string]interface{}{
+			"edits": []interface{}{
+				map[string]interface{}{"timestamp": float64(100), "content": "明天开会"},
+				map[string]interface{}{"timestamp": int64(200), "content": "明天下午开会"},
+			},
+		}},
+		{Sender: "b", Type: MessageTypeText, Content: "收到"},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+
+	want := []ChatLabEdit{{Timestamp: 100, Content: "明天开会"}, {Timestamp: 200, Content: "明天下午开会"}}
+	if !reflect.DeepEqual(cl.Messages[0].Edits, want) {
+		t.Errorf("edits = %+v, want %+v", cl.Messages[0].Edits, want)
+	}
+	if cl.Messages[0].Content != "明天下午三点开会" {
+		t.Errorf("content = %q, want latest version", cl.Messages[0].Content)
+	}
+	if b, _ := json.Marshal(cl.Messages[1]); bytes.Contains(b, []byte("edits")) {
+		t.Errorf("edits should be omitted: %s", b)
+	}
+}
+
+func TestConvertToChatLabTimestampMillis(t *testing.T) {
+	sent := time.UnixMilli(1703001600123)
+	messages := []*Message{
+		{Time: sent, Sender: "a", Type: MessageTypeText, Content: "你好", Contents: map[string]interface{}{
+			"edits": []interface{}{map[string]interface{}{"timestamp": float64(1703001500), "content": "您好"}},
+		}},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.TimestampUnit = TimestampUnitMillis
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if got := cl.Messages[0].Timestamp; got != 1703001600123 {
+		t.Errorf("timestamp = %d, want 1703001600123", got)
+	}
+	if got := cl.Messages[0].Edits[0].Timestamp; got != 1703001500000 {
+		t.Errorf("edit timestamp = %d, want 1703001500000", got)
+	}
+	if now := time.Now().UnixMilli(); cl.ChatLab.ExportedAt < now-60000 || cl.ChatLab.ExportedAt > now {
+		t.Errorf("exportedAt = %d is not a millisecond epoch near %d", cl.ChatLab.ExportedAt, now)
+	}
+
+	if got := ConvertToChatLab(messages, "a", "A").Messages[0].Timestamp; got != 1703001600 {
+		t.Errorf("default timestamp = %d, want seconds", got)
+	}
+}
+
+func TestChatLabMillisecondHelpers(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	messages := []*Message{
+		{Time: time.Date(2024, 1, 2, 9, 0, 0, 0, loc), Sender: "a", Type: MessageTypeText, Content: "1"},
+		{Time: time.Date(2024, 1, 2, 9, 0, 30, 0, loc), Sender: "b", Type: MessageTypeText, Content: "2"},
+		{Time: time.Date(2024, 1, 3, 9, 0, 0, 0, loc), Sender: "a", Type: MessageTypeText, Content: "3"},
+	}
+	opts := DefaultConvertOptions()
+	opts.TimestampUnit = TimestampUnitMillis
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	if cl.ChatLab.TimestampUnit != TimestampUnitMillis {
+		t.Fatalf("timestampUnit = %q", cl.ChatLab.TimestampUnit)
+	}
+	if ConvertToChatLab(messages, "1@chatroom", "群").ChatLab.TimestampUnit != "" {
+		t.Error("seconds exports should not record a unit")
+	}
+
+	b, err := ConvertToGenericChatJSON(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`"timestamp_ms":%d`, messages[0].Time.UnixMilli()); !bytes.Contains(b, []byte(want)) {
+		t.Errorf("generic JSON lacks %s: %s", want, b)
+	}
+	var buf bytes.Buffer
+	cl.DebugDump(&buf)
+	if !strings.Contains(buf.String(), messages[0].Time.Local().Format(time.RFC3339)) {
+		t.Errorf("debug dump = %q", buf.String())
+	}
+	if got := len(FilterDay(cl, messages[0].Time, loc).Messages); got != 2 {
+		t.Errorf("FilterDay kept %d messages, want 2", got)
+	}
+	if dividers := cl.WithDateDividers(loc); len(dividers) != 5 || dividers[0].Content != "2024-01-02" {
+		t.Errorf("dividers = %+v", dividers)
+	}
+	if got := len(cl.GroupConsecutive(time.Minute)); got != 3 {
+		t.Errorf("groups = %d, want 3", got)
+	}
+	gaps := cl.CoverageGaps(time.Hour)
+	if len(gaps) != 1 || gaps[0].Duration() != 24*time.Hour-30*time.Second {
+		t.Errorf("gaps = %+v", gaps)
+	}
+	if sessions := cl.SegmentSessions(DefaultSessionGap); len(sessions) != 2 {
+		t.Errorf("sessions = %+v", sessions)
+	}
+	if got := cl.ResponseTimes()["b"].Mean; got != 30*time.Second {
+		t.Errorf("response mean = %v, want 30s", got)
+	}
+	buf.Reset()
+	if err := RenderPlainText(&buf, cl, RenderOptions{Location: loc}); err != nil || !strings.HasPrefix(buf.String(), "2024-01-02 09:00:00 ") {
+		t.Errorf("plain text = %q, err %v", buf.String(), err)
+	}
+}
+
+func TestConvertToChatLabProgress(t *testing.T) {
+	messages := make([]*Message, 25)
+	for i := range messages {
+		messages[i] = &Message{Sender: "a", Type: MessageTypeText, Content: "hi"}
+	}
+
+	var calls [][2]int
+	opts := DefaultConvertOptions()
+	opts.ProgressEvery = 10
+	opts.Progress = func(done, total int) {
+		calls = append(calls, [2]int{done, total})
+	}
+	ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	want := [][2]int{{10, 25}, {20, 25}, {25, 25}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("progress calls = %v, want %v", calls, want)
+	}
+}
+
+func TestConvertToChatLabAnimatedSticker(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": "aaa", "cdnurl": "http://emoji.qpic.cn/wx_emoji/x.gif?wxfrom=1"}},
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": "bbb", "isanimated": "1"}},
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": "ccc", "format": "png", "cdnurl": "http://emoji.qpic.cn/wx_emoji/y.gif"}},
+		{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": "ddd"}},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+
+	for i, want := range []bool{true, true, false, false} {
+		if got := cl.Messages[i].Animated; got != want {
+			t.Errorf("messages[%d].Animated = %v, want %v", i, got, want)
+		}
+	}
+	if b, _ := json.Marshal(cl.Messages[3]); bytes.Contains(b, []byte("animated")) {
+		t.Errorf("animated should be omitted when unknown: %s", b)
+	}
+}
+
+func TestConvertToChatLabStickerPack(t *testing.T) {
+	packed := &Message{Sender: "a", Type: MessageTypeAnimation}
+	if err := packed.ParseMediaInfo(`<msg><emoji md5="aaa" cdnurl="http://emoji.qpic.cn/wx_emoji/x" productid="com.tencent.xin.emoticon.person.stiker_1234" designerid="designer_42" /></msg>`); err != nil {
+		t.Fatal(err)
+	}
+	custom := &Message{Sender: "a", Type: MessageTypeAnimation, Contents: map[string]interface{}{"md5": "bbb"}}
+
+	cl := ConvertToChatLab([]*Message{packed, custom}, "a", "A")
+
+	if got := cl.Messages[0]; got.StickerPack != "com.tencent.xin.emoticon.person.stiker_1234" || got.StickerAuthor != "designer_42" {
+		t.Errorf("packed sticker = %+v", got)
+	}
+	if b, _ := json.Marshal(cl.Messages[1]); bytes.Contains(b, []byte("sticker")) {
+		t.Errorf("sticker fields should be omitted when absent: %s", b)
+	}
+}
+
+func TestChatLabMemberNormalizeAvatar(t *testing.T) {
+	base := t.TempDir()
+	abs := filepath.Join(base, "abs.jpg")
+	tests := []struct {
+		avatar  string
+		want    string
+		wantErr bool
+	}{
+		{avatar: "", want: ""},
+		{avatar: "https://wx.qlogo.cn/mmhead/abc/0", want: "https://wx.qlogo.cn/mmhead/abc/0"},
+		{avatar: "data:image/png;base64,AAAA", want: "data:image/png;base64,AAAA"},
+		{avatar: "avatars/a.jpg", want: filepath.Join(base, "avatars", "a.jpg")},
+		{avatar: abs, want: abs},
+		{avatar: "file://" + filepath.ToSlash(abs), want: abs},
+		{avatar: "ftp://example.com/a.jpg", want: "ftp://example.com/a.jpg", wantErr: true},
+	}
+	for _, tt := range tests {
+		m := ChatLabMember{PlatformID: "a", Avatar: tt.avatar}
+		err := m.NormalizeAvatar(base)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeAvatar(%q) error = %v, wantErr %v", tt.avatar, err, tt.wantErr)
+		}
+		if tt.wantErr && !errors.Is(err, ErrAvatarScheme) {
+			t.Errorf("NormalizeAvatar(%q) error = %v, want ErrAvatarScheme", tt.avatar, err)
+		}
+		if m.Avatar != tt.want {
+			t.Errorf("NormalizeAvatar(%q) = %q, want %q", tt.avatar, m.Avatar, tt.want)
+		}
+	}
+}
+
+func TestConvertToChatLabNormalizeTimestamps(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(1703001600123, 0), Sender: "a", Type: MessageTypeText, Content: "毫秒"},
+		{Time: time.Unix(1703001700, 0), Sender: "a", Type: MessageTypeText, Content: "秒"},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+	if got := cl.Messages[0].Timestamp; got != 1703001600 {
+		t.Errorf("ms timestamp = %d, want 1703001600", got)
+	}
+	if got := cl.Messages[1].Timestamp; got != 1703001700 {
+		t.Errorf("s timestamp = %d, want 1703001700", got)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.NormalizeTimestamps = false
+	if got := ConvertToChatLabWithOptions(messages, "a", "A", opts).Messages[0].Timestamp; got != 1703001600123 {
+		t.Errorf("timestamp without normalization = %d, want raw value", got)
+	}
+}
+
+func TestChatLabApplyNameHistory(t *testing.T) {
+	cl := ChatLab{Members: []ChatLabMember{
+		{PlatformID: "a", AccountName: "张三", Aliases: []string{"三哥"}},
+		{PlatformID: "b"},
+		{PlatformID: "c", AccountName: "王五"},
+	}}
+
+	cl.ApplyNameHistory(map[string][]string{
+		"a": {"小张", "张三", "三哥", "小张", "Zhang"},
+		"b": {"李四", "四哥"},
+	})
+
+	want := []ChatLabMember{
+		{PlatformID: "a", AccountName: "张三", Aliases: []string{"三哥", "小张", "Zhang"}},
+		{PlatformID: "b", AccountName: "四哥", Aliases: []string{"李四"}},
+		{PlatformID: "c", AccountName: "王五"},
+	}
+	if !reflect.DeepEqual(cl.Members, want) {
+		t.Errorf("members = %+v, want %+v", cl.Members, want)
+	}
+}
+
+func TestConvertToChatLabContext(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "你好"},
+		{Sender: "b", Type: MessageTypeText, Content: "你好"},
+	}
+
+	cl, err := ConvertToChatLabContext(context.Background(), messages, "a", "A")
+	if err != nil || len(cl.Messages) != 2 {
+		t.Fatalf("got %d messages, err %v", len(cl.Messages), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl, err = ConvertToChatLabContext(ctx, messages, "a", "A")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if len(cl.Messages) != 0 || cl.Meta.Name != "A" {
+		t.Errorf("partial result = %+v", cl)
+	}
+}
+
+func TestConvertToChatLabMaxMessages(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "1"},
+		{Sender: "b", Type: MessageTypeText, Content: "2"},
+		{Sender: "a", Type: MessageTypeText, Content: "3"},
+	}
+
+	opts := DefaultConvertOptions()
+	cl, err := ConvertToChatLabLimit(context.Background(), messages, "a", "A", opts, 2)
+	if !errors.Is(err, ErrTooManyMessages) {
+		t.Fatalf("err = %v, want ErrTooManyMessages", err)
+	}
+	if !strings.Contains(err.Error(), "3") || len(cl.Messages) != 0 {
+		t.Errorf("err = %v, messages = %d", err, len(cl.Messages))
+	}
+
+	if cl, err = ConvertToChatLabLimit(context.Background(), messages, "a", "A", opts, 3); err != nil || len(cl.Messages) != 3 {
+		t.Errorf("at the limit: %d messages, err %v", len(cl.Messages), err)
+	}
+	if cl, err = ConvertToChatLabLimit(context.Background(), messages, "a", "A", opts, 0); err != nil || len(cl.Messages) != 3 {
+		t.Errorf("unlimited: %d messages, err %v", len(cl.Messages), err)
+	}
+}
+
+func TestConvertToChatLabDirection(t *testing.T) {
+	messages := []*Message{
+		{Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "在吗"},
+		{Sender: "a", Type: MessageTypeText, Content: "在"},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.IncludeDirection = true
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+	if cl.Messages[0].Direction != DirectionOut || cl.Messages[1].Direction != DirectionIn {
+		t.Errorf("directions = %q, %q", cl.Messages[0].Direction, cl.Messages[1].Direction)
+	}
+
+	if b, _ := json.Marshal(ConvertToChatLab(messages, "a", "A").Messages[0]); bytes.Contains(b, []byte("direction")) {
+		t.Errorf("direction should be omitted by default: %s", b)
+	}
+}
+
+func TestConvertToChatLabOriginalContent(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "\ufeff你好  \r\n明天\u200b见"},
+		{Sender: "a", Type: MessageTypeText, Content: "不用改"},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.NormalizeContent = true
+	opts.KeepOriginalContent = true
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if got := cl.Messages[0].Content; got != "你好\n明天见" {
+		t.Errorf("content = %q", got)
+	}
+	if got := cl.Messages[0].OriginalContent; got != messages[0].Content {
+		t.Errorf("original = %q, want %q", got, messages[0].Content)
+	}
+	if b, _ := json.Marshal(cl.Messages[1]); bytes.Contains(b, []byte("_original")) {
+		t.Errorf("_original should be omitted when unchanged: %s", b)
+	}
+
+	opts.KeepOriginalContent = false
+	if got := ConvertToChatLabWithOptions(messages, "a", "A", opts).Messages[0].OriginalContent; got != "" {
+		t.Errorf("original = %q without KeepOriginalContent", got)
+	}
+}
+
+func TestConvertToChatLabEncryptedImage(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/abc/Img/1.dat"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/abc/Img/2.dat"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/abc/Img/3.jpg"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/abc/2024-01/Img/0123456789abcdef"}},
+		{Sender: "a", Type: MessageTypeImage},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+	if msg := cl.Messages[0]; msg.Content != "msg/attach/abc/Img/1.dat" || !msg.Encrypted {
+		t.Errorf("without resolver = %+v, want encrypted .dat", msg)
+	}
+	if msg := cl.Messages[3]; !msg.Encrypted {
+		t.Errorf("v4 image without extension = %+v, want encrypted", msg)
+	}
+	if msg := cl.Messages[4]; msg.Content != "[图片]" || msg.Encrypted {
+		t.Errorf("placeholder = %+v", msg)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.DecryptDat = func(datPath string) (string, error) {
+		if strings.HasSuffix(datPath, "2.dat") {
+			return "", errors.New("unknown key")
+		}
+		return strings.TrimSuffix(datPath, filepath.Ext(datPath)) + ".png", nil
+	}
+	cl = ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	if msg := cl.Messages[0]; msg.Content != "msg/attach/abc/Img/1.png" || msg.Encrypted {
+		t.Errorf("decrypted = %+v", msg)
+	}
+	if msg := cl.Messages[1]; msg.Content != "msg/attach/abc/Img/2.dat" || !msg.Encrypted {
+		t.Errorf("failed decrypt = %+v, want encrypted .dat", msg)
+	}
+	if cl.Messages[2].Encrypted {
+		t.Error("plain image marked encrypted")
+	}
+	if msg := cl.Messages[3]; msg.Content != "msg/attach/abc/2024-01/Img/0123456789abcdef.png" || msg.Encrypted {
+		t.Errorf("decrypted v4 image = %+v", msg)
+	}
+}
+
+func TestConvertToChatLabVoiceFormat(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"path": "msg/voice/1.silk"}},
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"path": "msg/voice/2.amr"}},
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"voice": "123"}},
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"path": "msg/voice/3.aud"}},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+	for i, want := range []struct{ content, format string }{
+		{"msg/voice/1.silk", VoiceFormatSilk},
+		{"msg/voice/2.amr", ""},
+		{"[语音]", VoiceFormatSilk},
+		{"msg/voice/3.aud", ""},
+	} {
+		if got := cl.Messages[i]; got.Content != want.content || got.VoiceFormat != want.format {
+			t.Errorf("messages[%d] = %q (%q), want %q (%q)", i, got.Content, got.VoiceFormat, want.content, want.format)
+		}
+	}
+
+	opts := DefaultConvertOptions()
+	opts.TranscodeVoice = func(path, format string) (string, string, error) {
+		switch {
+		case strings.HasSuffix(path, ".amr"):
+			return "", "", errors.New("unsupported")
+		case strings.HasSuffix(path, ".aud"):
+			return "msg/voice/3.out", VoiceFormatOGG, nil
+		case path == "123":
+			if format != VoiceFormatSilk {
+				t.Errorf("voice key format = %q, want silk", format)
+			}
+			return "voice/123.mp3", "", nil
+		}
+		return strings.TrimSuffix(path, "."+format) + ".wav", "", nil
+	}
+	cl = ConvertToChatLabWithOptions(messages, "a", "A", opts)
+	if got := cl.Messages[0]; got.Content != "msg/voice/1.wav" || got.VoiceFormat != VoiceFormatWAV {
+		t.Errorf("transcoded = %q (%q)", got.Content, got.VoiceFormat)
+	}
+	if got := cl.Messages[1]; got.Content != "msg/voice/2.amr" || got.VoiceFormat != "" {
+		t.Errorf("failed transcode = %q (%q), want original", got.Content, got.VoiceFormat)
+	}
+	if got := cl.Messages[2]; got.Content != "voice/123.mp3" || got.VoiceFormat != VoiceFormatMP3 {
+		t.Errorf("transcoded voice key = %q (%q)", got.Content, got.VoiceFormat)
+	}
+	if got := cl.Messages[3]; got.Content != "msg/voice/3.out" || got.VoiceFormat != VoiceFormatOGG {
+		t.Errorf("reported format = %q (%q)", got.Content, got.VoiceFormat)
+	}
+}
+
+func TestConvertToChatLabVoicePlayed(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"isplayed": true}},
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"isplayed": "0"}},
+		{Sender: "a", Type: MessageTypeVoice, Contents: map[string]interface{}{"isplayed": int64(1)}},
+		{Sender: "a", Type: MessageTypeVoice},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+
+	for i, want := range []string{`"played":true`, `"played":false`, `"played":true`, ""} {
+		b, _ := json.Marshal(cl.Messages[i])
+		if want == "" {
+			if bytes.Contains(b, []byte("played")) {
+				t.Errorf("messages[%d] should omit played: %s", i, b)
+			}
+		} else if !bytes.Contains(b, []byte(want)) {
+			t.Errorf("messages[%d] = %s, want %s", i, b, want)
+		}
+	}
+}
+
+func TestConvertToChatLabUnmappedTypes(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "你好"},
+		{Sender: "a", Type: 9999, Content: "?"},
+		{Sender: "a", Type: 9999, Content: "?"},
+		{Sender: "a", Type: MessageTypeShare, SubType: 4000, Content: "未知分享"},
+		{Sender: "a", Type: 10002, Content: "?"},
+	}
+
+	var stats ConvertStats
+	opts := DefaultConvertOptions()
+	opts.Stats = &stats
+	ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	// Unknown share subtypes still map to SHARE and are not counted.
+	want := map[int]int{9999: 2, 10002: 1}
+	if !reflect.DeepEqual(stats.UnmappedTypes, want) {
+		t.Errorf("unmapped = %v, want %v", stats.UnmappedTypes, want)
+	}
+}
+
+func TestConvertToChatLabFileSize(t *testing.T) {
+	msg := &Message{Type: MessageTypeShare, Sender: "a"}
+	if err := msg.ParseMediaInfo(`<msg><appmsg><title>报告.pdf</title><type>6</type><appattach><totallen>1258291</totallen></appattach></appmsg></msg>`); err != nil {
+		t.Fatal(err)
+	}
+
+	got := ConvertToChatLab([]*Message{msg}, "a", "A").Messages[0]
+	if got.Type != ChatLabTypeFile || got.Content != "报告.pdf" || got.FileSize != 1258291 {
+		t.Errorf("file = %+v", got)
+	}
+}
+
+func TestConvertToChatLabChannelShare(t *testing.T) {
+	msg := &Message{Type: MessageTypeShare, Sender: "a"}
+	err := msg.ParseMediaInfo(`<msg><appmsg><title>当前微信版本不支持展示该内容</title><type>51</type>` +
+		`<finderFeed><nickname>美食频道</nickname><desc>今天做
+红烧肉</desc><mediaList><media><url>https://finder.video.qq.com/v.mp4</url>` +
+		`<thumbUrl>https://finder.video.qq.com/t.jpg</thumbUrl></media></mediaList></finderFeed></appmsg></msg>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ConvertToChatLab([]*Message{msg}, "a", "A").Messages[0]
+
+	if got.Type != ChatLabTypeShare || got.Content != "今天做 红烧肉" {
+		t.Errorf("message = %+v", got)
+	}
+	want := ChatLabShare{Title: "今天做 红烧肉", URL: "https://finder.video.qq.com/v.mp4", Author: "美食频道", Thumb: "https://finder.video.qq.com/t.jpg"}
+	if got.Share == nil || *got.Share != want {
+		t.Errorf("share = %+v, want %+v", got.Share, want)
+	}
+}
+
+func TestConvertToChatLabPlaceholders(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"md5": "abc"}},
+		{Sender: "a", Type: MessageTypeVoice},
+		{Sender: "a", Type: MessageTypeVideo},
+	}
+
+	opts := DefaultConvertOptions()
+	opts.Placeholders = map[int]string{ChatLabTypeImage: "[Image]", ChatLabTypeVoice: "[Voice]"}
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+
+	for i, want := range []string{"[Image]", "[Voice]", "[视频]"} {
+		if got := cl.Messages[i].Content; got != want {
+			t.Errorf("messages[%d].Content = %q, want %q", i, got, want)
+		}
+	}
+	if refs := cl.MediaRefs(); len(refs) != 1 || refs[0].Kind != MediaRefKindMD5 {
+		t.Errorf("media refs = %+v, want the image md5 only", refs)
+	}
+}
+
+func TestChatLabGroupNicknameOmittedWhenRedundant(t *testing.T) {
+	messages := []*Message{{Sender: "a", SenderName: "张三", Type: MessageTypeText, Content: "你好"}}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+	b, _ := json.Marshal(cl)
+	if bytes.Contains(b, []byte("groupNickname")) {
+		t.Errorf("groupNickname should be omitted when it equals accountName: %s", b)
+	}
+
+	// Group display names recorded by the repository
+	messages = []*Message{
+		{Sender: "a", SenderName: "群主-张三", Type: MessageTypeText, Content: "开会", GroupNickname: "群主-张三", AccountName: "张三"},
+		{Sender: "b", SenderName: "李四", Type: MessageTypeText, Content: "好", GroupNickname: "李四", AccountName: "李四"},
+	}
+	cl = ConvertToChatLab(messages, "1@chatroom", "群")
+	if m := cl.Messages[0]; m.AccountName != "张三" || m.GroupNickname != "群主-张三" {
+		t.Errorf("messages[0] = %+v, want distinct group nickname", m)
+	}
+	if m := cl.Messages[1]; m.AccountName != "李四" || m.GroupNickname != "" {
+		t.Errorf("messages[1] = %+v, want redundant nickname omitted", m)
+	}
+	if cl.Members[0].GroupNickname != "群主-张三" {
+		t.Errorf("members = %+v", cl.Members)
+	}
+	if got := ConvertToChatLab(messages[:1], "a", "A").Messages[0].GroupNickname; got != "" {
+		t.Errorf("private chat group nickname = %q", got)
+	}
+
+	// Messages read back from an export may carry a distinct group nickname.
+	members := collectMembers([]ChatLabMessage{
+		{Sender: "a", AccountName: "张三", GroupNickname: "群主-张三"},
+		{Sender: "b", AccountName: "李四", GroupNickname: "李四"},
+	}, nil, true)
+	if members[0].GroupNickname != "群主-张三" || members[1].GroupNickname != "" {
+		t.Errorf("members = %+v", members)
+	}
+	if b, _ := json.Marshal(members[0]); !bytes.Contains(b, []byte(`"groupNickname":"群主-张三"`)) {
+		t.Errorf("distinct groupNickname should be emitted: %s", b)
+	}
+}
+
+func TestChatLabRows(t *testing.T) {
+	messages := []*Message{
+		transferMessage(t, 100, "a", 1, "￥66.00", "奶茶", ""),
+		{Time: time.Unix(200, 0), Sender: "b", SenderName: "B", Type: MessageTypeLocation, Contents: map[string]interface{}{"poiname": "国贸大厦", "label": "北京市朝阳区建国门外大街1号"}},
+	}
+
+	rows := ConvertToChatLab(messages, "1@chatroom", "群").Rows()
+
+	want := []ChatLabRow{
+		{ConversationName: "群", Platform: "wechat", Sender: "a", Timestamp: 100, Type: ChatLabTypeTransfer, TypeName: "TRANSFER",
+			Content: "[转账|发送 ￥66.00](奶茶)", PaymentAmount: "66.00", PaymentCurrency: "CNY", PaymentMemo: "奶茶", PaymentStatus: PaymentStatusSend},
+		{ConversationName: "群", Platform: "wechat", Sender: "b", AccountName: "B", Timestamp: 200, Type: ChatLabTypeLocation, TypeName: "LOCATION",
+			Content: "国贸大厦", LocationName: "国贸大厦", LocationAddress: "北京市朝阳区建国门外大街1号"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %+v", rows)
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("rows[%d] = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestChatLabRowsCollapseNewlines(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeText, Content: "第一行\n第二行\r\n第三行"},
+	}
+	cl := ConvertToChatLab(messages, "a", "A")
+
+	if got := cl.RowsWithOptions(RowOptions{CollapseNewlines: true})[0].Content; got != "第一行 第二行 第三行" {
+		t.Errorf("collapsed = %q", got)
+	}
+	if got := cl.RowsWithOptions(RowOptions{CollapseNewlines: true, NewlineSeparator: " / "})[0].Content; got != "第一行 / 第二行 / 第三行" {
+		t.Errorf("custom separator = %q", got)
+	}
+	if got := cl.Rows()[0].Content; got != cl.Messages[0].Content {
+		t.Errorf("default rows = %q, want original newlines", got)
+	}
+	if cl.Messages[0].Content != messages[0].Content {
+		t.Errorf("messages should keep newlines: %q", cl.Messages[0].Content)
+	}
+}
+
+func TestConvertToArchiveSchema(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(1700000000, 0), Sender: "me", IsSelf: true, Type: MessageTypeText, Content: "早"},
+		{Time: time.Unix(1700000060, 0), Sender: "xm", SenderName: "小明", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "msg/attach/1.jpg"}},
+	}
+	cl := ConvertToChatLab(messages, "xm", "小明")
+
+	data, err := ConvertToArchiveSchema(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ArchiveChat
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Name != "小明" || !reflect.DeepEqual(got.Participants, []string{"我", "小明"}) {
+		t.Errorf("chat = %+v", got)
+	}
+	if len(got.Messages) != len(cl.Messages) {
+		t.Fatalf("messages = %+v", got.Messages)
+	}
+	for i, m := range got.Messages {
+		src := cl.Messages[i]
+		date, err := time.Parse(time.RFC3339, m.Date)
+		if err != nil || date.Unix() != src.Timestamp {
+			t.Errorf("messages[%d].Date = %q, want %d", i, m.Date, src.Timestamp)
+		}
+		if m.Author != src.AccountName || m.Content != src.Content {
+			t.Errorf("messages[%d] = %+v, source %+v", i, m, src)
+		}
+	}
+	if got.Messages[0].Type != "text" || got.Messages[1].Type != "image" {
+		t.Errorf("types = %q, %q", got.Messages[0].Type, got.Messages[1].Type)
+	}
+}
+
+func TestChatLabRetarget(t *testing.T) {
+	cl := ChatLab{
+		Meta: ChatLabMeta{Name: "群", Platform: "wechat", Type: "group"},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Type: ChatLabTypeText, Content: "你好"},
+			{Sender: "b", Type: ChatLabTypeReply, Content: "好", ReplyTo: &ChatLabReplyTo{Sender: "a", Type: ChatLabTypeText, Content: "你好"}},
+			{Sender: "a", Type: ChatLabTypeForward, Children: []ChatLabMessage{{Sender: "c", Type: ChatLabTypeImage, Content: "[图片]"}}},
+		},
+	}
+	before := cl.Messages
+
+	identity := TypeMapperFunc(func(t int) (int, bool) { return t, true })
+	if err := cl.Retarget("generic", identity); err != nil {
+		t.Fatal(err)
+	}
+	if cl.Meta.Platform != "generic" || !reflect.DeepEqual(cl.Messages, before) {
+		t.Errorf("identity retarget changed messages: %+v", cl.Messages)
+	}
+
+	textOnly := TypeMapperFunc(func(t int) (int, bool) { return ChatLabTypeText, t != ChatLabTypeImage })
+	err := cl.Retarget("viewer", textOnly)
+	if !errors.Is(err, ErrUnmappedType) || !strings.Contains(err.Error(), "messages[2]: children[0]") {
+		t.Errorf("err = %v, want ErrUnmappedType at messages[2].children[0]", err)
+	}
+	if cl.Meta.Platform != "generic" || cl.Messages[1].Type != ChatLabTypeReply {
+		t.Error("failed retarget should leave the export unchanged")
+	}
+
+	collapse := TypeMapperFunc(func(t int) (int, bool) { return ChatLabTypeText, true })
+	if err := cl.Retarget("viewer", collapse); err != nil {
+		t.Fatal(err)
+	}
+	if cl.Messages[1].Type != ChatLabTypeText || cl.Messages[1].ReplyTo.Type != ChatLabTypeText || cl.Messages[1].Content != "好" {
+		t.Errorf("retargeted reply = %+v", cl.Messages[1])
+	}
+	if before[1].Type != ChatLabTypeReply || before[2].Children[0].Type != ChatLabTypeImage {
+		t.Error("retarget should not write through to shared slices")
+	}
+}
+
+func TestExportRoster(t *testing.T) {
+	cl := ChatLab{
+		Members: []ChatLabMember{
+			{PlatformID: "a", AccountName: "张三", Aliases: []string{"三哥"}, Avatar: "https://wx.qlogo.cn/a"},
+			{PlatformID: "b", AccountName: "李四", IsSelf: true},
+		},
+		Messages: []ChatLabMessage{{Sender: "a", Content: "不应导出"}},
+	}
+
+	data, err := ExportRoster(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []ChatLabMember
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("roster is not a JSON array: %v: %s", err, data)
+	}
+	if !reflect.DeepEqual(got, cl.Members) {
+		t.Errorf("roster = %+v, want %+v", got, cl.Members)
+	}
+	if bytes.Contains(data, []byte("不应导出")) {
+		t.Errorf("roster leaked messages: %s", data)
+	}
+
+	if data, _ := ExportRoster(ChatLab{}); string(data) != "[]" {
+		t.Errorf("empty roster = %s, want []", data)
+	}
+}
+
+func TestConvertToChatLabNow(t *testing.T) {
+	fixed := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	opts := DefaultConvertOptions()
+	opts.Now = func() time.Time { return fixed }
+
+	if got := ConvertToChatLabWithOptions(nil, "a", "A", opts).ChatLab.ExportedAt; got != fixed.Unix() {
+		t.Errorf("exportedAt = %d, want %d", got, fixed.Unix())
+	}
+
+	opts.TimestampUnit = TimestampUnitMillis
+	if got := ConvertToChatLabWithOptions(nil, "a", "A", opts).ChatLab.ExportedAt; got != fixed.UnixMilli() {
+		t.Errorf("exportedAt = %d, want %d", got, fixed.UnixMilli())
+	}
+}
+
+func TestConvertToChatLabCaption(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "img/1.jpg", "caption": "今天的晚霞"}},
+		{Sender: "a", Type: MessageTypeVideo, Contents: map[string]interface{}{"text": "猫"}},
+		{Sender: "a", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "img/2.jpg"}},
+	}
+
+	cl := ConvertToChatLab(messages, "a", "A")
+
+	if msg := cl.Messages[0]; msg.Content != "img/1.jpg" || msg.Caption != "今天的晚霞" {
+		t.Errorf("captioned image = %+v", msg)
+	}
+	if msg := cl.Messages[1]; msg.Content != "[视频]" || msg.Caption != "猫" {
+		t.Errorf("captioned video = %+v", msg)
+	}
+	if b, _ := json.Marshal(cl.Messages[2]); bytes.Contains(b, []byte("caption")) {
+		t.Errorf("caption should be omitted: %s", b)
+	}
+}
+
+func TestConvertToChatLabDetectLang(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", Type: MessageTypeText, Content: "你好"},
+		{Sender: "b", Type: MessageTypeText, Content: "hello"},
+		{Sender: "b", Type: MessageTypeImage, Contents: map[string]interface{}{"path": "1.jpg"}},
+	}
+
+	var calls int
+	opts := DefaultConvertOptions()
+	opts.DetectLang = func(text string) string {
+		calls++
+		for _, r := range text {
+			if r > 0x2e80 {
+				return "zh"
+			}
+		}
+		return "en"
+	}
+	cl := ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+
+	for i, want := range []string{"zh", "en", ""} {
+		if got := cl.Messages[i].Lang; got != want {
+			t.Errorf("messages[%d].Lang = %q, want %q", i, got, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("detector called %d times, want 2 (text only)", calls)
+	}
+
+	if b, _ := json.Marshal(ConvertToChatLab(messages, "1@chatroom", "群").Messages[0]); bytes.Contains(b, []byte(`"lang"`)) {
+		t.Errorf("lang should be omitted without a detector: %s", b)
+	}
+}
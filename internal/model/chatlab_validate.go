@@ -0,0 +1,155 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ValidateOptions enables the optional, advisory checks of ChatLab.Validate
+type ValidateOptions struct {
+	// CheckMonotonic flags messages whose timestamp is earlier than the previous one
+	CheckMonotonic bool
+
+	// CheckSelf flags exports with no member marked IsSelf, which breaks
+	// "my messages" highlighting; see ConvertOptions.SelfID
+	CheckSelf bool
+}
+
+// ChatLabIssue is a problem found by ChatLab.Validate.
+// Index is the message index, or -1 for header/meta/member issues.
+type ChatLabIssue struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (i ChatLabIssue) String() string {
+	if i.Index < 0 {
+		return fmt.Sprintf("%s: %s", i.Field, i.Message)
+	}
+	return fmt.Sprintf("messages[%d].%s: %s", i.Index, i.Field, i.Message)
+}
+
+// Validate checks the export against the required fields of the ChatLab spec.
+// It never modifies cl; use SortMessages to repair ordering problems.
+func (cl ChatLab) Validate(opts ValidateOptions) []ChatLabIssue {
+	issues := make([]ChatLabIssue, 0)
+	add := func(index int, field, format string, args ...interface{}) {
+		issues = append(issues, ChatLabIssue{Index: index, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cl.ChatLab.Version == "" {
+		add(-1, "chatlab.version", "missing")
+	}
+	if cl.Meta.Name == "" {
+		add(-1, "meta.name", "missing")
+	}
+	if cl.Meta.Platform == "" {
+		add(-1, "meta.platform", "missing")
+	}
+	if cl.Meta.Type != "group" && cl.Meta.Type != "private" {
+		add(-1, "meta.type", "must be group or private, got %q", cl.Meta.Type)
+	}
+	hasSelf := false
+	for i, m := range cl.Members {
+		if m.PlatformID == "" {
+			add(-1, fmt.Sprintf("members[%d].platformId", i), "missing")
+		}
+		hasSelf = hasSelf || m.IsSelf
+	}
+	if opts.CheckSelf && !hasSelf && (cl.Meta.Type == "group" || cl.Meta.Type == "private") {
+		add(-1, "members", "no member is marked isSelf")
+	}
+
+	for i, msg := range cl.Messages {
+		if msg.Sender == "" {
+			add(i, "sender", "missing")
+		}
+		if opts.CheckMonotonic && i > 0 && msg.Timestamp < cl.Messages[i-1].Timestamp {
+			add(i, "timestamp", "%d is earlier than previous message %d", msg.Timestamp, cl.Messages[i-1].Timestamp)
+		}
+	}
+
+	return issues
+}
+
+// SortMessages stable-sorts messages by timestamp, keeping the original order of
+// messages sharing a timestamp. RefundOf is remapped to the new indexes; other
+// index-based references computed beforehand are no longer valid, and Seq
+// fields assigned before sorting may end up out of order.
+func (cl *ChatLab) SortMessages() {
+	order := make([]int, len(cl.Messages))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return cl.Messages[order[i]].Timestamp < cl.Messages[order[j]].Timestamp
+	})
+
+	moved := make([]int, len(order))
+	for to, from := range order {
+		moved[from] = to
+	}
+	sorted := make([]ChatLabMessage, len(cl.Messages))
+	for to, from := range order {
+		msg := cl.Messages[from]
+		if msg.RefundOf != nil && *msg.RefundOf >= 0 && *msg.RefundOf < len(moved) {
+			index := moved[*msg.RefundOf]
+			msg.RefundOf = &index
+		}
+		sorted[to] = msg
+	}
+	copy(cl.Messages, sorted)
+}
+
+// EnsureMemberIntegrity appends a stub member, named from the first message's
+// AccountName, for every sender missing from Members. Run it after filtering
+// members so every message still resolves to one.
+func (cl *ChatLab) EnsureMemberIntegrity() {
+	known := make(map[string]bool, len(cl.Members))
+	for _, m := range cl.Members {
+		known[m.PlatformID] = true
+	}
+	for _, msg := range cl.Messages {
+		if msg.Sender == "" || known[msg.Sender] {
+			continue
+		}
+		known[msg.Sender] = true
+		cl.Members = append(cl.Members, ChatLabMember{
+			PlatformID:        msg.Sender,
+			AccountName:       msg.AccountName,
+			IsOfficialAccount: isOfficialAccount(msg.Sender),
+		})
+	}
+}
+
+// VerifyChecksum reports whether the header checksum matches the messages.
+// An export without a checksum never verifies.
+func (cl ChatLab) VerifyChecksum() bool {
+	return cl.ChatLab.Checksum != "" && cl.ChatLab.Checksum == messagesChecksum(cl.Messages)
+}
+
+// messagesChecksum is the hex SHA-256 over the JSON of each message in
+// canonical order (stable by timestamp), so reordering messages that are
+// otherwise unchanged keeps it valid. Any change to a message invalidates it,
+// including a remapped index such as RefundOf.
+func messagesChecksum(messages []ChatLabMessage) string {
+	order := make([]int, len(messages))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return messages[order[i]].Timestamp < messages[order[j]].Timestamp
+	})
+
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, i := range order {
+		// ChatLabMessage always marshals
+		_ = enc.Encode(messages[i])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
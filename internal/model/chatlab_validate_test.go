@@ -0,0 +1,149 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChatLabValidate(t *testing.T) {
+	cl := ChatLab{
+		ChatLab: ChatLabHeader{Version: "0.0.1"},
+		Meta:    ChatLabMeta{Name: "群", Platform: "wechat", Type: "group"},
+		Messages: []ChatLabMessage{
+			{Sender: "a", Timestamp: 100, Content: "1"},
+			{Sender: "b", Timestamp: 300, Content: "3"},
+			{Sender: "a", Timestamp: 200, Content: "2"},
+			{Sender: "", Timestamp: 300, Content: "4"},
+		},
+	}
+
+	issues := cl.Validate(ValidateOptions{})
+	if len(issues) != 1 || issues[0].Index != 3 || issues[0].Field != "sender" {
+		t.Errorf("default issues = %v, want missing sender only", issues)
+	}
+
+	issues = cl.Validate(ValidateOptions{CheckMonotonic: true})
+	if len(issues) != 2 || issues[0].Index != 2 || issues[0].Field != "timestamp" {
+		t.Errorf("monotonic issues = %v, want timestamp issue at 2", issues)
+	}
+
+	cl.SortMessages()
+	for i, want := range []string{"1", "2", "3", "4"} {
+		if cl.Messages[i].Content != want {
+			t.Errorf("sorted messages[%d] = %q, want %q", i, cl.Messages[i].Content, want)
+		}
+	}
+	for _, issue := range cl.Validate(ValidateOptions{CheckMonotonic: true}) {
+		if issue.Field == "timestamp" {
+			t.Errorf("unexpected issue after sort: %v", issue)
+		}
+	}
+
+	invalid := ChatLab{Meta: ChatLabMeta{Type: "channel"}}
+	if got := len(invalid.Validate(ValidateOptions{})); got != 4 {
+		t.Errorf("invalid header issues = %d, want 4", got)
+	}
+}
+
+func TestChatLabSortMessagesRemapsRefunds(t *testing.T) {
+	transfer := 2
+	cl := ChatLab{Messages: []ChatLabMessage{
+		{Sender: "b", Timestamp: 300, Type: ChatLabTypeTransfer, Content: "退还", RefundOf: &transfer},
+		{Sender: "a", Timestamp: 100, Type: ChatLabTypeText, Content: "转你"},
+		{Sender: "a", Timestamp: 200, Type: ChatLabTypeTransfer, Content: "转账"},
+	}}
+
+	cl.SortMessages()
+
+	refund := cl.Messages[2]
+	if refund.RefundOf == nil || cl.Messages[*refund.RefundOf].Content != "转账" {
+		t.Errorf("refundOf = %v, want the transfer at 1", refund.RefundOf)
+	}
+	if transfer != 2 {
+		t.Error("the original RefundOf pointer should not be modified")
+	}
+}
+
+func TestChatLabValidateCheckSelf(t *testing.T) {
+	messages := []*Message{
+		{Sender: "wxid_me", SenderName: "老王", Type: MessageTypeText, Content: "大家好"},
+		{Sender: "wxid_b", SenderName: "B", Type: MessageTypeText, Content: "你好"},
+	}
+
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+	issues := cl.Validate(ValidateOptions{CheckSelf: true})
+	if len(issues) != 1 || issues[0].Field != "members" {
+		t.Errorf("issues = %v, want missing self warning", issues)
+	}
+	if got := cl.Validate(ValidateOptions{}); len(got) != 0 {
+		t.Errorf("self check should be opt-in: %v", got)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.SelfID = "wxid_me"
+	cl = ConvertToChatLabWithOptions(messages, "1@chatroom", "群", opts)
+	if got := cl.Validate(ValidateOptions{CheckSelf: true}); len(got) != 0 {
+		t.Errorf("issues with SelfID = %v", got)
+	}
+	if !cl.Members[0].IsSelf || cl.Members[1].IsSelf {
+		t.Errorf("members = %+v", cl.Members)
+	}
+	if messages[0].IsSelf {
+		t.Error("SelfID should not modify the input messages")
+	}
+}
+
+func TestChatLabVerifyChecksum(t *testing.T) {
+	messages := []*Message{
+		{Time: time.Unix(100, 0), Sender: "a", Type: MessageTypeText, Content: "一"},
+		{Time: time.Unix(200, 0), Sender: "b", Type: MessageTypeText, Content: "二"},
+	}
+	if cl := ConvertToChatLab(messages, "a", "A"); cl.ChatLab.Checksum != "" || cl.VerifyChecksum() {
+		t.Errorf("checksum should be opt-in: %q", cl.ChatLab.Checksum)
+	}
+
+	opts := DefaultConvertOptions()
+	opts.IncludeChecksum = true
+	cl := ConvertToChatLabWithOptions(messages, "a", "A", opts)
+	if len(cl.ChatLab.Checksum) != 64 || !cl.VerifyChecksum() {
+		t.Fatalf("checksum %q does not verify", cl.ChatLab.Checksum)
+	}
+
+	opts.Reverse = true
+	if reversed := ConvertToChatLabWithOptions(messages, "a", "A", opts); reversed.ChatLab.Checksum != cl.ChatLab.Checksum {
+		t.Errorf("reversed checksum = %q, want %q", reversed.ChatLab.Checksum, cl.ChatLab.Checksum)
+	}
+
+	tampered := cl
+	tampered.Messages = append([]ChatLabMessage(nil), cl.Messages...)
+	tampered.Messages[1].Content = "三"
+	if tampered.VerifyChecksum() {
+		t.Error("modified message should invalidate the checksum")
+	}
+	if !cl.VerifyChecksum() {
+		t.Error("original should still verify")
+	}
+}
+
+func TestChatLabEnsureMemberIntegrity(t *testing.T) {
+	messages := []*Message{
+		{Sender: "a", SenderName: "A", Type: MessageTypeText, Content: "1"},
+		{Sender: "b", SenderName: "B", Type: MessageTypeText, Content: "2"},
+		{Sender: "b", SenderName: "B2", Type: MessageTypeText, Content: "3"},
+	}
+	cl := ConvertToChatLab(messages, "1@chatroom", "群")
+	cl.Members = cl.Members[:1] // a member filter dropped b but kept their messages
+
+	cl.EnsureMemberIntegrity()
+
+	want := []ChatLabMember{{PlatformID: "a", AccountName: "A"}, {PlatformID: "b", AccountName: "B"}}
+	if !reflect.DeepEqual(cl.Members, want) {
+		t.Errorf("members = %+v, want %+v", cl.Members, want)
+	}
+
+	cl.EnsureMemberIntegrity()
+	if len(cl.Members) != 2 {
+		t.Errorf("second pass should add nothing: %+v", cl.Members)
+	}
+}
@@ -36,6 +36,16 @@ type Image struct {
 	// CdnThumbAesKey      string `xml:"cdnthumbaeskey,attr"`
 }
 
+// Card 名片消息，字段为 <msg> 根节点属性
+type Card struct {
+	XMLName         xml.Name `xml:"msg"`
+	Username        string   `xml:"username,attr"`
+	Nickname        string   `xml:"nickname,attr"`
+	Alias           string   `xml:"alias,attr"`
+	BigHeadImgURL   string   `xml:"bigheadimgurl,attr"`
+	SmallHeadImgURL string   `xml:"smallheadimgurl,attr"`
+}
+
 type Video struct {
 	Md5    string `xml:"md5,attr"`
 	RawMd5 string `xml:"rawmd5,attr"`
@@ -88,14 +98,14 @@ type Emoji struct {
 	AesKey       string `xml:"aeskey,attr"`
 	Width        string `xml:"width,attr"`
 	Height       string `xml:"height,attr"`
-	// ProductId         string `xml:"productid,attr"`
+	ProductId    string `xml:"productid,attr"`
+	DesignerId   string `xml:"designerid,attr"`
 	// AndroidMd5        string `xml:"androidmd5,attr"`
 	// AndroidLen        string `xml:"androidlen,attr"`
 	// S60v3Md5          string `xml:"s60v3md5,attr"`
 	// S60v3Len          string `xml:"s60v3len,attr"`
 	// S60v5Md5          string `xml:"s60v5md5,attr"`
 	// S60v5Len          string `xml:"s60v5len,attr"`
-	// DesignerId        string `xml:"designerid,attr"`
 	// ThumbUrl          string `xml:"thumburl,attr"`
 	// EncryptUrl        string `xml:"encrypturl,attr"`
 	// ExternUrl         string `xml:"externurl,attr"`
@@ -118,7 +128,7 @@ type Location struct {
 	MapType  string `xml:"maptype,attr"`
 	Adcode   string `xml:"adcode,attr"`
 	CityName string `xml:"cityname,attr"`
-	// PoiName         string `xml:"poiname,attr"`
+	PoiName  string `xml:"poiname,attr"`
 	// PoiId           string `xml:"poiid,attr"`
 	// BuildingId      string `xml:"buildingId,attr"`
 	// FloorName       string `xml:"floorName,attr"`
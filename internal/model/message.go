@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/xml"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -118,6 +119,11 @@ type Message struct {
 	SubType    int64                  `json:"subType"`            // 消息子类型
 	Content    string                 `json:"content"`            // 消息内容，文字聊天内容
 	Contents   map[string]interface{} `json:"contents,omitempty"` // 消息内容，多媒体消息，采用更灵活的记录方式
+	RawContent string                 `json:"-"`                  // 原始 appmsg XML，用于取证核对
+
+	// 群聊中发送人的群昵称及其账号名称（备注或昵称），仅用于导出
+	GroupNickname string `json:"-"`
+	AccountName   string `json:"-"`
 
 	// Debug Info
 	MediaMsg *MediaMsg `json:"mediaMsg,omitempty"` // 原始多媒体消息，XML 格式
@@ -162,6 +168,10 @@ func (m *Message) ParseMediaInfo(data string) error {
 		m.MediaMsg = &msg
 	}
 
+	if m.Type == MessageTypeShare {
+		m.RawContent = data
+	}
+
 	switch m.Type {
 	case MessageTypeImage:
 		m.Contents["md5"] = msg.Image.MD5
@@ -174,11 +184,39 @@ func (m *Message) ParseMediaInfo(data string) error {
 		}
 	case MessageTypeAnimation:
 		m.Contents["cdnurl"] = msg.Emoji.CdnURL
+		if msg.Emoji.Md5 != "" {
+			m.Contents["md5"] = msg.Emoji.Md5
+		}
+		if msg.Emoji.ProductId != "" {
+			m.Contents["productid"] = msg.Emoji.ProductId
+		}
+		if msg.Emoji.DesignerId != "" {
+			m.Contents["author"] = msg.Emoji.DesignerId
+		}
+	case MessageTypeCard:
+		var card Card
+		if err := xml.Unmarshal([]byte(data), &card); err != nil {
+			break
+		}
+		m.Contents["username"] = card.Username
+		m.Contents["nickname"] = card.Nickname
+		if card.Alias != "" {
+			m.Contents["alias"] = card.Alias
+		}
+		if card.BigHeadImgURL != "" {
+			m.Contents["bigheadimgurl"] = card.BigHeadImgURL
+		}
+		if card.SmallHeadImgURL != "" {
+			m.Contents["smallheadimgurl"] = card.SmallHeadImgURL
+		}
 	case MessageTypeLocation:
 		m.Contents["x"] = msg.Location.X
 		m.Contents["y"] = msg.Location.Y
 		m.Contents["label"] = msg.Location.Label
 		m.Contents["cityname"] = msg.Location.CityName
+		if msg.Location.PoiName != "" {
+			m.Contents["poiname"] = msg.Location.PoiName
+		}
 	case MessageTypeShare:
 		m.SubType = int64(msg.App.Type)
 		switch m.SubType {
@@ -187,10 +225,18 @@ func (m *Message) ParseMediaInfo(data string) error {
 			m.Contents["title"] = msg.App.Title
 			m.Contents["desc"] = msg.App.Des
 			m.Contents["url"] = msg.App.URL
+			if msg.App.SourceDisplayName != "" {
+				m.Contents["sourcedisplayname"] = msg.App.SourceDisplayName
+			}
 		case MessageSubTypeFile:
 			// 文件
 			m.Contents["title"] = msg.App.Title
 			m.Contents["md5"] = msg.App.MD5
+			if msg.App.AppAttach != nil {
+				if size, err := strconv.ParseInt(msg.App.AppAttach.TotalLen, 10, 64); err == nil {
+					m.Contents["size"] = size
+				}
+			}
 		case MessageSubTypeMergeForward, MessageSubTypeNote, MessageSubTypeChatRoomNotice:
 			// 合并转发 & 笔记
 			m.Contents["title"] = msg.App.Title
@@ -214,8 +260,17 @@ func (m *Message) ParseMediaInfo(data string) error {
 				break
 			}
 			m.Contents["title"] = strings.TrimSpace(strings.ReplaceAll(msg.App.FinderFeed.Desc, "\n", " "))
+			if msg.App.FinderFeed.Nickname != "" {
+				m.Contents["author"] = msg.App.FinderFeed.Nickname
+			}
 			if len(msg.App.FinderFeed.MediaList.Media) > 0 {
-				m.Contents["url"] = msg.App.FinderFeed.MediaList.Media[0].URL
+				media := msg.App.FinderFeed.MediaList.Media[0]
+				m.Contents["url"] = media.URL
+				if media.ThumbURL != "" {
+					m.Contents["thumb"] = media.ThumbURL
+				} else if media.CoverURL != "" {
+					m.Contents["thumb"] = media.CoverURL
+				}
 			}
 		case MessageSubTypeQuote:
 			// 引用
@@ -258,6 +313,9 @@ func (m *Message) ParseMediaInfo(data string) error {
 			m.Contents["title"] = msg.App.Title
 			m.Contents["desc"] = msg.App.Des
 			m.Contents["url"] = msg.App.URL
+			if msg.App.SourceDisplayName != "" {
+				m.Contents["sourcedisplayname"] = msg.App.SourceDisplayName
+			}
 		case MessageSubTypePay:
 			// 微信转账
 			if msg.App.WCPayInfo == nil {
@@ -282,6 +340,14 @@ func (m *Message) ParseMediaInfo(data string) error {
 				payMemo = "(" + msg.App.WCPayInfo.PayMemo + ")"
 			}
 			m.Content = fmt.Sprintf("[转账|%s%s]%s", _type, msg.App.WCPayInfo.FeeDesc, payMemo)
+			m.Contents["paysubtype"] = msg.App.WCPayInfo.PaySubType
+			m.Contents["feedesc"] = msg.App.WCPayInfo.FeeDesc
+			if msg.App.WCPayInfo.PayMemo != "" {
+				m.Contents["paymemo"] = msg.App.WCPayInfo.PayMemo
+			}
+			if msg.App.WCPayInfo.TransferID != "" {
+				m.Contents["transferid"] = msg.App.WCPayInfo.TransferID
+			}
 		}
 	}
 
@@ -60,9 +60,13 @@ func (r *Repository) enrichMessage(msg *model.Message) {
 		if chatRoom, ok := r.chatRoomCache[msg.Talker]; ok {
 			msg.TalkerName = chatRoom.DisplayName()
 
-			// 补充发送者在群里的显示名称
+			// 补充发送者在群里的显示名称，并保留其账号名称
 			if displayName, ok := chatRoom.User2DisplayName[msg.Sender]; ok {
 				msg.SenderName = displayName
+				msg.GroupNickname = displayName
+				if contact := r.getFullContact(msg.Sender); contact != nil {
+					msg.AccountName = contact.DisplayName()
+				}
 			}
 		}
 	}